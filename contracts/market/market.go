@@ -0,0 +1,6 @@
+package market
+
+var MarketABI = `[
+{"type":"event","name":"CreateOrder","inputs":[{"name":"cp","type":"address","indexed":true},{"name":"id","type":"uint64"},{"name":"nid","type":"uint64"},{"name":"act","type":"uint256"},{"name":"pro","type":"uint256"},{"name":"dur","type":"uint256"},{"name":"status","type":"uint8"}]},
+{"type":"event","name":"Withdraw","inputs":[{"name":"cp","type":"address","indexed":true},{"name":"amount","type":"uint256"}]}
+]`
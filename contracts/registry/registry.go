@@ -0,0 +1,12 @@
+package registry
+
+var RegistryABI = `[
+{"type":"event","name":"Register","inputs":[{"name":"cp","type":"address","indexed":true},{"name":"name","type":"string"},{"name":"ip","type":"string"},{"name":"domain","type":"string"},{"name":"port","type":"string"}]},
+{"type":"event","name":"AddNode","inputs":[{"name":"cp","type":"address","indexed":true},{"name":"id","type":"uint64"},
+ {"name":"cpu","type":"tuple","components":[{"name":"cpuPriceMon","type":"uint256"},{"name":"cpuPriceSec","type":"uint256"},{"name":"model","type":"string"},{"name":"core","type":"uint64"}]},
+ {"name":"gpu","type":"tuple","components":[{"name":"gpuPriceMon","type":"uint256"},{"name":"gpuPriceSec","type":"uint256"},{"name":"model","type":"string"}]},
+ {"name":"mem","type":"tuple","components":[{"name":"memPriceMon","type":"uint256"},{"name":"memPriceSec","type":"uint256"},{"name":"num","type":"uint64"}]},
+ {"name":"disk","type":"tuple","components":[{"name":"diskPriceMon","type":"uint256"},{"name":"diskPriceSec","type":"uint256"},{"name":"num","type":"uint64"}]},
+ {"name":"exist","type":"bool"},{"name":"sold","type":"bool"},{"name":"avail","type":"bool"}]},
+{"type":"event","name":"DelNode","inputs":[{"name":"cp","type":"address","indexed":true},{"name":"id","type":"uint64"}]}
+]`
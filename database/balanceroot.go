@@ -0,0 +1,161 @@
+package database
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/gridprotocol/dumper/merkle"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gorm.io/gorm"
+)
+
+// merkle root over all provider withdrawable balances at a block
+type BalanceRoot struct {
+	Id          uint64    `gorm:"primaryKey" json:"id"`
+	BlockNumber uint64    `gorm:"uniqueIndex" json:"blockNumber"`
+	Time        time.Time `json:"time"`
+	Root        string    `json:"root"`
+	Count       int       `json:"count"` // number of leaves
+}
+
+// a leaf of a balance root, leaves are sorted by address
+type BalanceLeaf struct {
+	Id       uint64   `gorm:"primaryKey" json:"id"`
+	RootId   uint64   `gorm:"index:idx_balance_leaf,unique" json:"rootId"`
+	Index    int      `gorm:"index:idx_balance_leaf,unique" json:"index"`
+	Address  string   `gorm:"index" json:"address"`
+	Balance  *big.Int `gorm:"serializer:bigint" json:"balance"`
+	Nonce    uint64   `json:"nonce"`
+	LeafHash string   `json:"leafHash"`
+}
+
+// inclusion proof of a provider balance
+type BalanceProof struct {
+	Root        string      `json:"root"`
+	BlockNumber uint64      `json:"blockNumber"`
+	Leaf        BalanceLeaf `json:"leaf"`
+	Proof       []string    `json:"proof"`
+}
+
+func InitBalanceRoot() error {
+	return GlobalDataBase.AutoMigrate(&BalanceRoot{}, &BalanceLeaf{})
+}
+
+// build and store the merkle root of all provider balances at a block.
+// an existing root of the block is returned as is
+func CreateBalanceRoot(blockNumber uint64, t time.Time) (BalanceRoot, error) {
+	var root BalanceRoot
+	err := GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&BalanceRoot{}).Where("block_number = ?", blockNumber).Find(&root).Error
+		if err != nil || root.Id != 0 {
+			return err
+		}
+
+		var profits []ProfitStore
+		err = tx.Model(&ProfitStore{}).Order("address").Find(&profits).Error
+		if err != nil {
+			return err
+		}
+
+		leaves := make([]BalanceLeaf, 0, len(profits))
+		hashes := make([]common.Hash, 0, len(profits))
+		for i, p := range profits {
+			// the amount a voucher would be signed for
+			balance := p.withdrawable()
+			h, err := merkle.Leaf(common.HexToAddress(p.Address), balance, p.Nonce)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, h)
+			leaves = append(leaves, BalanceLeaf{
+				Index:    i,
+				Address:  p.Address,
+				Balance:  balance,
+				Nonce:    p.Nonce,
+				LeafHash: h.Hex(),
+			})
+		}
+
+		root = BalanceRoot{
+			BlockNumber: blockNumber,
+			Time:        t,
+			Root:        merkle.New(hashes).Root().Hex(),
+			Count:       len(leaves),
+		}
+		err = tx.Create(&root).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range leaves {
+			leaves[i].RootId = root.Id
+		}
+		if len(leaves) == 0 {
+			return nil
+		}
+
+		return tx.CreateInBatches(leaves, 100).Error
+	})
+	if err != nil {
+		return BalanceRoot{}, err
+	}
+
+	return root, nil
+}
+
+// get the balance root of a block, the latest one if block number is 0
+func GetBalanceRoot(blockNumber uint64) (BalanceRoot, error) {
+	db := GlobalDataBase.Model(&BalanceRoot{})
+	if blockNumber == 0 {
+		db = db.Order("block_number DESC")
+	} else {
+		db = db.Where("block_number = ?", blockNumber)
+	}
+
+	var root BalanceRoot
+	err := db.First(&root).Error
+	if err != nil {
+		return BalanceRoot{}, err
+	}
+
+	return root, nil
+}
+
+// inclusion proof of a provider balance in the root of a block, the latest one if block number is 0
+func GetBalanceProof(address string, blockNumber uint64) (BalanceProof, error) {
+	root, err := GetBalanceRoot(blockNumber)
+	if err != nil {
+		return BalanceProof{}, err
+	}
+
+	var leaves []BalanceLeaf
+	err = GlobalDataBase.Model(&BalanceLeaf{}).Where("root_id = ?", root.Id).Order("`index`").Find(&leaves).Error
+	if err != nil {
+		return BalanceProof{}, err
+	}
+
+	pos := -1
+	hashes := make([]common.Hash, len(leaves))
+	for i, l := range leaves {
+		hashes[i] = common.HexToHash(l.LeafHash)
+		if l.Address == address {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return BalanceProof{}, gorm.ErrRecordNotFound
+	}
+
+	proof := []string{}
+	for _, h := range merkle.New(hashes).Proof(pos) {
+		proof = append(proof, h.Hex())
+	}
+
+	return BalanceProof{
+		Root:        root.Root,
+		BlockNumber: root.BlockNumber,
+		Leaf:        leaves[pos],
+		Proof:       proof,
+	}, nil
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// digits of the max uint256
+const bigIntWidth = 78
+
+// 10^78, offset of negative values
+var bigIntOffset = new(big.Int).Exp(big.NewInt(10), big.NewInt(bigIntWidth), nil)
+
+func init() {
+	schema.RegisterSerializer("bigint", BigIntSerializer{})
+}
+
+// BigIntSerializer stores a *big.Int as a zero-padded decimal string with fixed width,
+// so the column can be compared and sorted as a number in sql.
+// usage: Price *big.Int `gorm:"serializer:bigint"`
+type BigIntSerializer struct{}
+
+// Scan implements serializer interface
+func (BigIntSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var v *big.Int
+
+	switch s := dbValue.(type) {
+	case nil:
+	case string:
+		n, err := DecodeBigInt(s)
+		if err != nil {
+			return err
+		}
+		v = n
+	case []byte:
+		n, err := DecodeBigInt(string(s))
+		if err != nil {
+			return err
+		}
+		v = n
+	default:
+		return xerrors.Errorf("unsupported big int value %v", dbValue)
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(reflect.ValueOf(v))
+	return nil
+}
+
+// Value implements serializer interface
+func (BigIntSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	v, ok := fieldValue.(*big.Int)
+	if !ok {
+		return nil, xerrors.Errorf("%s is not a *big.Int", field.Name)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	return EncodeBigInt(v), nil
+}
+
+// encode v as it is stored in db, use it as the argument when comparing with a big int column.
+// a negative value is stored as "-" followed by the padded 10^78+v, which is ordered before all non-negative values.
+// the order holds for values in [-10^78, 10^78), which covers int256 and uint256
+func EncodeBigInt(v *big.Int) string {
+	if v.Sign() < 0 {
+		return "-" + fmt.Sprintf("%0*s", bigIntWidth, new(big.Int).Add(bigIntOffset, v).String())
+	}
+
+	return fmt.Sprintf("%0*s", bigIntWidth, v.String())
+}
+
+// decode a big int from db, plain decimal strings are accepted too
+func DecodeBigInt(s string) (*big.Int, error) {
+	if strings.HasPrefix(s, "-") && len(s) == bigIntWidth+1 {
+		v, ok := new(big.Int).SetString(s[1:], 10)
+		if !ok {
+			return nil, xerrors.Errorf("Failed to convert %s to BigInt", s)
+		}
+		return v.Sub(v, bigIntOffset), nil
+	}
+
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, xerrors.Errorf("Failed to convert %s to BigInt", s)
+	}
+
+	return v, nil
+}
+
+// rewrite big int columns stored as plain decimal strings to the padded form
+func migrateBigInt(db *gorm.DB) error {
+	var nodes []NodeStore
+	err := db.Model(&NodeStore{}).Where(legacyBigInt(
+		"cpu_price_mon", "cpu_price_sec", "gpu_price_mon", "gpu_price_sec",
+		"mem_price_mon", "mem_price_sec", "disk_price_mon", "disk_price_sec",
+	)).Find(&nodes).Error
+	if err != nil {
+		return err
+	}
+
+	var profits []ProfitStore
+	err = db.Model(&ProfitStore{}).Where(legacyBigInt("balance", "profit", "penalty")).Find(&profits).Error
+	if err != nil {
+		return err
+	}
+
+	if len(nodes) == 0 && len(profits) == 0 {
+		return nil
+	}
+
+	logger.Info("migrate big int columns, nodes: ", len(nodes), " profits: ", len(profits))
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := range nodes {
+			err := tx.Save(&nodes[i]).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := range profits {
+			err := tx.Save(&profits[i]).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// condition of rows having any column not in the padded form
+func legacyBigInt(columns ...string) string {
+	conds := make([]string, len(columns))
+	for i, c := range columns {
+		conds[i] = fmt.Sprintf("LENGTH(%s) < %d", c, bigIntWidth)
+	}
+
+	return strings.Join(conds, " OR ")
+}
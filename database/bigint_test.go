@@ -0,0 +1,181 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+)
+
+func bigIntValues() []*big.Int {
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	return []*big.Int{
+		new(big.Int).Neg(bigIntOffset),
+		new(big.Int).Neg(maxUint256),
+		big.NewInt(-1000),
+		big.NewInt(-1),
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(1000),
+		maxUint256,
+		new(big.Int).Sub(bigIntOffset, big.NewInt(1)),
+	}
+}
+
+func TestEncodeBigInt(t *testing.T) {
+	values := bigIntValues()
+
+	var encoded []string
+	for _, v := range values {
+		s := EncodeBigInt(v)
+		width := bigIntWidth
+		if v.Sign() < 0 {
+			width++
+		}
+		if len(s) != width {
+			t.Fatalf("%s encoded as %s of %d digits", v, s, len(s))
+		}
+
+		d, err := DecodeBigInt(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Cmp(v) != 0 {
+			t.Fatalf("%s decoded as %s", v, d)
+		}
+		encoded = append(encoded, s)
+	}
+
+	// the values are in order, so are their encodings
+	if !sort.StringsAreSorted(encoded) {
+		t.Fatalf("encodings not ordered: %v", encoded)
+	}
+
+	// plain decimal strings of old versions
+	for _, s := range []string{"0", "42", "-42"} {
+		d, err := DecodeBigInt(s)
+		if err != nil || d.String() != s {
+			t.Fatalf("%s decoded as %v: %v", s, d, err)
+		}
+	}
+	_, err := DecodeBigInt("4x")
+	if err == nil {
+		t.Fatal("invalid big int decoded")
+	}
+}
+
+func TestBigIntColumnOrder(t *testing.T) {
+	err := InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := bigIntValues()
+	for i := len(values) - 1; i >= 0; i-- {
+		p := Profit{Address: fmt.Sprintf("0x%040d", i), Balance: values[i], Profit: big.NewInt(0), Penalty: big.NewInt(0)}
+		err = p.CreateProfit()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ps []ProfitStore
+	err = GlobalDataBase.Model(&ProfitStore{}).Order("balance").Find(&ps).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != len(values) {
+		t.Fatalf("%d rows", len(ps))
+	}
+	for i, p := range ps {
+		if p.Balance.Cmp(values[i]) != 0 {
+			t.Fatalf("row %d balance %s, want %s", i, p.Balance, values[i])
+		}
+	}
+
+	// compared as numbers
+	var cnt int64
+	err = GlobalDataBase.Model(&ProfitStore{}).Where("balance < ?", EncodeBigInt(big.NewInt(0))).Count(&cnt).Error
+	if err != nil || cnt != 4 {
+		t.Fatal("negative balances: ", cnt, err)
+	}
+}
+
+func TestMigrateBigInt(t *testing.T) {
+	err := InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := "0x1111111111111111111111111111111111111111"
+	node := NodeStore{Address: address, Id: 1, CPUPriceSec: big.NewInt(1), CPUPriceMon: big.NewInt(1), Exist: true}
+	err = node.CreateNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node2 := NodeStore{Address: address, Id: 2, CPUPriceSec: big.NewInt(10), CPUPriceMon: big.NewInt(1), Exist: true}
+	err = node2.CreateNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Profit{Address: address, Balance: big.NewInt(0), Profit: big.NewInt(0), Penalty: big.NewInt(0)}
+	err = p.CreateProfit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// values as old versions stored them
+	err = GlobalDataBase.Exec("UPDATE node_stores SET cpu_price_sec = ? WHERE id = 1", "5").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GlobalDataBase.Exec("UPDATE profit_stores SET balance = ?, profit = ?", "-7", "30").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrateBigInt(GlobalDataBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		CpuPriceSec string
+		CpuPriceMon string
+	}
+	err = GlobalDataBase.Raw("SELECT cpu_price_sec, cpu_price_mon FROM node_stores WHERE id = 1").Scan(&raw).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.CpuPriceSec != EncodeBigInt(big.NewInt(5)) || raw.CpuPriceMon != EncodeBigInt(big.NewInt(1)) {
+		t.Fatalf("node prices not padded: %+v", raw)
+	}
+
+	var balance, profit string
+	err = GlobalDataBase.Raw("SELECT balance FROM profit_stores").Scan(&balance).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GlobalDataBase.Raw("SELECT profit FROM profit_stores").Scan(&profit).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != EncodeBigInt(big.NewInt(-7)) || profit != EncodeBigInt(big.NewInt(30)) {
+		t.Fatalf("profit not padded: %s %s", balance, profit)
+	}
+
+	// migrated prices compare as numbers
+	nodes, _, err := NewNodeQuery().MaxPrice(SortCPUPriceSec, big.NewInt(6)).Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Id != 1 {
+		t.Fatalf("unexpected nodes %+v", nodes)
+	}
+
+	// nothing left to migrate
+	err = migrateBigInt(GlobalDataBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// resource summary of a provider, updated with its nodes and orders
+type ProviderCapacity struct {
+	Address string `gorm:"primarykey"`
+
+	NNode int64 // existing nodes
+	UNode int64 // nodes in orders
+	NMem  int64
+	UMem  int64
+	NDisk int64
+	UDisk int64
+}
+
+// sort keys of provider listing by free capacity
+const (
+	SortFreeNode = "free_node"
+	SortFreeMem  = "free_mem"
+	SortFreeDisk = "free_disk"
+)
+
+var freeCapacityExprs = map[string]string{
+	SortFreeNode: "COALESCE(pc.n_node, 0) - COALESCE(pc.u_node, 0)",
+	SortFreeMem:  "COALESCE(pc.n_mem, 0) - COALESCE(pc.u_mem, 0)",
+	SortFreeDisk: "COALESCE(pc.n_disk, 0) - COALESCE(pc.u_disk, 0)",
+}
+
+func InitProviderCapacity() error {
+	return GlobalDataBase.AutoMigrate(&ProviderCapacity{})
+}
+
+// add node resource of a provider, negative values to remove
+func addNodeCapacity(db *gorm.DB, address string, node, mem, disk int64) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "address"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"n_node": gorm.Expr("n_node + ?", node),
+			"n_mem":  gorm.Expr("n_mem + ?", mem),
+			"n_disk": gorm.Expr("n_disk + ?", disk),
+		}),
+	}).Create(&ProviderCapacity{Address: address, NNode: node, NMem: mem, NDisk: disk}).Error
+}
+
+// add used resource of a provider, negative values to release
+func addUsedCapacity(db *gorm.DB, address string, node, mem, disk int64) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "address"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"u_node": gorm.Expr("u_node + ?", node),
+			"u_mem":  gorm.Expr("u_mem + ?", mem),
+			"u_disk": gorm.Expr("u_disk + ?", disk),
+		}),
+	}).Create(&ProviderCapacity{Address: address, UNode: node, UMem: mem, UDisk: disk}).Error
+}
+
+// get capacity of a provider, zero if it has no node
+func GetProviderCapacity(address string) (ProviderCapacity, error) {
+	var pc ProviderCapacity
+	err := GlobalDataBase.Model(&ProviderCapacity{}).Where("address = ?", address).Find(&pc).Error
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	pc.Address = address
+
+	return pc, nil
+}
+
+// recompute all provider capacities from nodes and orders
+func RebuildProviderCapacity() error {
+	return rebuildProviderCapacity(GlobalDataBase)
+}
+
+// RebuildProviderCapacity within a transaction of the caller
+func RebuildProviderCapacityTx(db *gorm.DB) error {
+	return rebuildProviderCapacity(db)
+}
+
+func rebuildProviderCapacity(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("1 = 1").Delete(&ProviderCapacity{}).Error
+		if err != nil {
+			return err
+		}
+
+		// existing nodes
+		var total []ProviderCapacity
+		err = tx.Model(&NodeStore{}).
+			Select("address, COUNT(*) AS n_node, SUM(mem_capacity) AS n_mem, SUM(disk_capacity) AS n_disk").
+			Where("exist = ?", true).
+			Group("address").
+			Scan(&total).Error
+		if err != nil {
+			return err
+		}
+		for _, pc := range total {
+			err = addNodeCapacity(tx, pc.Address, pc.NNode, pc.NMem, pc.NDisk)
+			if err != nil {
+				return err
+			}
+		}
+
+		// nodes in orders not completed or cancelled
+		var used []ProviderCapacity
+		err = tx.Model(&Order{}).
+			Select("orders.provider AS address, COUNT(*) AS u_node, SUM(ns.mem_capacity) AS u_mem, SUM(ns.disk_capacity) AS u_disk").
+			Joins("JOIN node_stores ns ON ns.address = orders.provider AND ns.id = orders.nid").
+			Where("orders.status < ?", OrderCancelled).
+			Group("orders.provider").
+			Scan(&used).Error
+		if err != nil {
+			return err
+		}
+		for _, pc := range used {
+			err = addUsedCapacity(tx, pc.Address, pc.UNode, pc.UMem, pc.UDisk)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ProviderQuery lists providers filtered and sorted by free capacity
+type ProviderQuery struct {
+	MinFreeNode int64
+	MinFreeMem  int64
+	MinFreeDisk int64
+
+	SortBy string // free_node, free_mem or free_disk, default by address
+	Desc   bool
+
+	Start int
+	Num   int // <0 for all
+}
+
+// query of providers joined with their capacity
+func providersWithCapacity() *gorm.DB {
+	return GlobalDataBase.Model(&Provider{}).
+		Select("providers.*, COALESCE(pc.n_node, 0) AS n_node, COALESCE(pc.u_node, 0) AS u_node, " +
+			"COALESCE(pc.n_mem, 0) AS n_mem, COALESCE(pc.u_mem, 0) AS u_mem, " +
+			"COALESCE(pc.n_disk, 0) AS n_disk, COALESCE(pc.u_disk, 0) AS u_disk").
+		Joins("LEFT JOIN provider_capacities pc ON pc.address = providers.address")
+}
+
+// list providers with capacity and nodes
+func ListProviders(q ProviderQuery) ([]ProviderAdaptor, error) {
+	db := providersWithCapacity()
+
+	if q.MinFreeNode > 0 {
+		db = db.Where(freeCapacityExprs[SortFreeNode]+" >= ?", q.MinFreeNode)
+	}
+	if q.MinFreeMem > 0 {
+		db = db.Where(freeCapacityExprs[SortFreeMem]+" >= ?", q.MinFreeMem)
+	}
+	if q.MinFreeDisk > 0 {
+		db = db.Where(freeCapacityExprs[SortFreeDisk]+" >= ?", q.MinFreeDisk)
+	}
+
+	dir := " ASC"
+	if q.Desc {
+		dir = " DESC"
+	}
+	if q.SortBy != "" {
+		expr, ok := freeCapacityExprs[q.SortBy]
+		if !ok {
+			return nil, xerrors.Errorf("can not sort providers by %s", q.SortBy)
+		}
+		db = db.Order(expr + dir)
+	}
+	db = db.Order("providers.address" + dir)
+
+	var rows []providerRow
+	err := db.Limit(q.Num).Offset(q.Start).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return adaptProviders(rows)
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// dead letter status
+const (
+	DeadLetterPending uint8 = iota
+	DeadLetterResolved
+	DeadLetterDiscarded
+)
+
+// longest delay between retries
+const maxDeadLetterDelay = 24 * time.Hour
+
+// a log whose handler failed, kept for retry or inspection
+type DeadLetter struct {
+	Id          uint64    `gorm:"primaryKey" json:"id"`
+	Contract    string    `json:"contract"`
+	Event       string    `json:"event"`
+	Log         string    `json:"log"` // the log as json
+	BlockNumber uint64    `json:"blockNumber"`
+	TxHash      string    `gorm:"uniqueIndex:idx_dead_letter_log" json:"txHash"`
+	LogIndex    uint      `gorm:"uniqueIndex:idx_dead_letter_log" json:"logIndex"`
+	Error       string    `json:"error"` // last error
+	Attempts    uint64    `json:"attempts"`
+	Status      uint8     `gorm:"index" json:"status"`
+	FirstFailed time.Time `json:"firstFailed"`
+	LastFailed  time.Time `json:"lastFailed"`
+	NextRetry   time.Time `gorm:"index" json:"nextRetry"`
+}
+
+func InitDeadLetter() error {
+	return GlobalDataBase.AutoMigrate(&DeadLetter{})
+}
+
+// record a failure of a log at LastFailed, an existing entry counts another attempt.
+// the next retry is delayed by backoff doubling per attempt, a discarded entry is returned unchanged
+func (d *DeadLetter) AddDeadLetter(backoff time.Duration) error {
+	return GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		var old DeadLetter
+		err := tx.Model(&DeadLetter{}).Where("tx_hash = ? AND log_index = ?", d.TxHash, d.LogIndex).First(&old).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			d.Attempts = 1
+			d.Status = DeadLetterPending
+			d.FirstFailed = d.LastFailed
+			d.NextRetry = d.LastFailed.Add(retryDelay(backoff, d.Attempts))
+			return tx.Create(d).Error
+		case err != nil:
+			return err
+		}
+
+		if old.Status == DeadLetterDiscarded {
+			*d = old
+			return nil
+		}
+
+		old.Error = d.Error
+		old.Attempts++
+		old.Status = DeadLetterPending
+		old.LastFailed = d.LastFailed
+		old.NextRetry = d.LastFailed.Add(retryDelay(backoff, old.Attempts))
+		*d = old
+
+		return tx.Save(d).Error
+	})
+}
+
+func retryDelay(backoff time.Duration, attempts uint64) time.Duration {
+	delay := backoff
+	for i := uint64(1); i < attempts && delay < maxDeadLetterDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDeadLetterDelay {
+		delay = maxDeadLetterDelay
+	}
+
+	return delay
+}
+
+// get the entry of a log, gorm.ErrRecordNotFound if the log never failed
+func GetDeadLetterByLog(txHash string, logIndex uint) (DeadLetter, error) {
+	var d DeadLetter
+	err := GlobalDataBase.Model(&DeadLetter{}).Where("tx_hash = ? AND log_index = ?", txHash, logIndex).First(&d).Error
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	return d, nil
+}
+
+// set the status of an entry
+func SetDeadLetterStatus(id uint64, status uint8) error {
+	result := GlobalDataBase.Model(&DeadLetter{}).Where("id = ?", id).Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// get an entry by id
+func GetDeadLetter(id uint64) (DeadLetter, error) {
+	var d DeadLetter
+	err := GlobalDataBase.Model(&DeadLetter{}).Where("id = ?", id).First(&d).Error
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	return d, nil
+}
+
+// entries of a status by specify start and num
+func ListDeadLetters(status uint8, start, num int) ([]DeadLetter, error) {
+	var ds []DeadLetter
+	err := GlobalDataBase.Model(&DeadLetter{}).Where("status = ?", status).Order("id").Limit(num).Offset(start).Find(&ds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+// pending entries due for retry at t with less than max attempts, in log order
+func ListDueDeadLetters(t time.Time, maxAttempts uint64) ([]DeadLetter, error) {
+	var ds []DeadLetter
+	err := GlobalDataBase.Model(&DeadLetter{}).
+		Where("status = ? AND next_retry <= ? AND attempts < ?", DeadLetterPending, t, maxAttempts).
+		Order("block_number, log_index").
+		Find(&ds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
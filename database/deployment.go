@@ -0,0 +1,84 @@
+package database
+
+import (
+	"time"
+)
+
+// filter of user deployments
+type DeploymentFilter int
+
+const (
+	DeploymentAll     DeploymentFilter = iota
+	DeploymentActive                   // order not ended, cancelled or completed
+	DeploymentExpired                  // order ended, cancelled or completed
+)
+
+// a node held by a user through an order
+type Deployment struct {
+	OrderID   uint64 `json:"orderId"`
+	AppName   string `json:"appName"`
+	Status    uint8  `json:"status"`
+	StartTime int64  `json:"startTime"`
+	EndTime   int64  `json:"endTime"`
+	Remaining int64  `json:"remaining"` // seconds until the order ends
+
+	Node NodeAdaptor `json:"node"`
+}
+
+// joined row of order and node
+type deploymentRow struct {
+	OrderID     uint64
+	AppName     string
+	OrderStatus uint8
+	OrderStart  time.Time
+	OrderEnd    time.Time
+
+	NodeStore `gorm:"embedded"`
+}
+
+// list the (order, node) pairs of a user ordered by order id, num < 0 for all
+func ListUserDeployments(user string, filter DeploymentFilter, start, num int) ([]Deployment, error) {
+	now := time.Now()
+
+	db := GlobalDataBase.Model(&Order{}).
+		Select("orders.id AS order_id, orders.app_name, orders.status AS order_status, orders.start AS order_start, orders.end AS order_end, ns.*").
+		Joins("JOIN node_stores ns ON ns.address = orders.provider AND ns.id = orders.nid").
+		Where("orders.user = ?", user)
+
+	switch filter {
+	case DeploymentActive:
+		db = db.Where("orders.status < ? AND orders.end > ?", OrderCancelled, now)
+	case DeploymentExpired:
+		db = db.Where("orders.status >= ? OR orders.end <= ?", OrderCancelled, now)
+	}
+
+	var rows []deploymentRow
+	err := db.Order("orders.id").Limit(num).Offset(start).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	deps := []Deployment{}
+	for _, r := range rows {
+		remaining := int64(r.OrderEnd.Sub(now).Seconds())
+		if remaining < 0 || r.OrderStatus >= OrderCancelled {
+			remaining = 0
+		}
+
+		node := r.NodeStore.Adaptor()
+		node.AppName = r.AppName
+
+		deps = append(deps, Deployment{
+			OrderID:   r.OrderID,
+			AppName:   r.AppName,
+			Status:    r.OrderStatus,
+			StartTime: r.OrderStart.Unix(),
+			EndTime:   r.OrderEnd.Unix(),
+			Remaining: remaining,
+
+			Node: node,
+		})
+	}
+
+	return deps, nil
+}
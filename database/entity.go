@@ -0,0 +1,171 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// actions of a mapped event on an entity
+const (
+	EntityInsert    = "insert"    // add a row, once per log
+	EntityUpsert    = "upsert"    // set fields, the row is created if missing
+	EntityUpdate    = "update"    // set fields of an existing row
+	EntityIncrement = "increment" // add integer fields, the row is created if missing
+)
+
+// a row of a generic entity table filled by manifest mappings, fields are stored as a json object
+type Entity struct {
+	Id          uint64 `gorm:"primaryKey" json:"id"`
+	Entity      string `gorm:"uniqueIndex:idx_entity_key" json:"entity"`
+	Key         string `gorm:"uniqueIndex:idx_entity_key" json:"key"`
+	Data        string `json:"data"`
+	BlockNumber uint64 `json:"blockNumber"` // last change
+	TxHash      string `json:"txHash"`
+}
+
+func InitEntity() error {
+	return GlobalDataBase.AutoMigrate(&Entity{})
+}
+
+// apply an action with fields on the row of an entity key
+func ApplyEntity(action, entity, key string, fields map[string]interface{}, blockNumber uint64, txHash string) error {
+	return ApplyEntityTx(GlobalDataBase, action, entity, key, fields, blockNumber, txHash)
+}
+
+// ApplyEntity within a transaction of the caller
+func ApplyEntityTx(db *gorm.DB, action, entity, key string, fields map[string]interface{}, blockNumber uint64, txHash string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if action == EntityInsert {
+			data, err := json.Marshal(fields)
+			if err != nil {
+				return err
+			}
+
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Entity{
+				Entity:      entity,
+				Key:         key,
+				Data:        string(data),
+				BlockNumber: blockNumber,
+				TxHash:      txHash,
+			}).Error
+		}
+
+		var row Entity
+		err := tx.Model(&Entity{}).Where("entity = ? AND `key` = ?", entity, key).First(&row).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if action == EntityUpdate {
+				return nil
+			}
+			row = Entity{Entity: entity, Key: key, Data: "{}"}
+		case err != nil:
+			return err
+		}
+
+		data, err := decodeEntity(row.Data)
+		if err != nil {
+			return err
+		}
+
+		switch action {
+		case EntityUpsert, EntityUpdate:
+			for k, v := range fields {
+				data[k] = v
+			}
+		case EntityIncrement:
+			for k, v := range fields {
+				sum, err := addEntityInt(data[k], v)
+				if err != nil {
+					return xerrors.Errorf("increment %s.%s: %w", entity, k, err)
+				}
+				data[k] = sum
+			}
+		default:
+			return xerrors.Errorf("unknown entity action %s", action)
+		}
+
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		row.Data = string(b)
+		row.BlockNumber = blockNumber
+		row.TxHash = txHash
+
+		return tx.Save(&row).Error
+	})
+}
+
+// add two integers stored as decimal strings or numbers, the sum is a decimal string
+func addEntityInt(a, b interface{}) (string, error) {
+	x, err := entityInt(a)
+	if err != nil {
+		return "", err
+	}
+	y, err := entityInt(b)
+	if err != nil {
+		return "", err
+	}
+
+	return x.Add(x, y).String(), nil
+}
+
+func entityInt(v interface{}) (*big.Int, error) {
+	if v == nil {
+		return big.NewInt(0), nil
+	}
+
+	i, ok := new(big.Int).SetString(fmt.Sprint(v), 10)
+	if !ok {
+		return nil, xerrors.Errorf("%v is not an integer", v)
+	}
+
+	return i, nil
+}
+
+// decode entity fields, numbers are kept as json.Number to not lose precision
+func decodeEntity(s string) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	err := dec.Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// get the row of an entity key with its fields
+func GetEntity(entity, key string) (Entity, map[string]interface{}, error) {
+	var row Entity
+	err := GlobalDataBase.Model(&Entity{}).Where("entity = ? AND `key` = ?", entity, key).First(&row).Error
+	if err != nil {
+		return Entity{}, nil, err
+	}
+
+	data, err := decodeEntity(row.Data)
+	if err != nil {
+		return Entity{}, nil, err
+	}
+
+	return row, data, nil
+}
+
+// list rows of an entity by specify start and num
+func ListEntities(entity string, start, num int) ([]Entity, error) {
+	var rows []Entity
+	err := GlobalDataBase.Model(&Entity{}).Where("entity = ?", entity).Order("id").Limit(num).Offset(start).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
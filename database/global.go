@@ -16,6 +16,9 @@ type GlobalStore struct {
 	DiskGlobal int64 `json:"diskGlobal"`
 	MemUsed    int64 `json:"memUsed"`
 	DiskUsed   int64 `json:"diskUsed"`
+
+	// counters are kept by handlers, rows of older versions are repaired once
+	Maintained bool `json:"-"`
 }
 
 // create global table
@@ -30,8 +33,13 @@ func (g *GlobalStore) CreateGlobal() error {
 
 // IncCp 累加 GlobalStore 表中的 CpNum 字段
 func IncCp() error {
+	return incCp(GlobalDataBase)
+}
+
+// increase cp number within db or a transaction
+func incCp(db *gorm.DB) error {
 	// 假设 Id 为 0 的记录是需要更新的记录
-	result := GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumn("cp_num", gorm.Expr("cp_num + ?", 1))
+	result := db.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumn("cp_num", gorm.Expr("cp_num + ?", 1))
 	if result.Error != nil {
 		return result.Error
 	}
@@ -40,8 +48,13 @@ func IncCp() error {
 
 // accu node resource
 func IncNode(mem, disk int64) error {
+	return incNode(GlobalDataBase, mem, disk)
+}
+
+// accu node resource within db or a transaction
+func incNode(db *gorm.DB, mem, disk int64) error {
 	// 假设 Id 为 0 的记录是需要更新的记录
-	result := GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
+	result := db.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
 		"node_global": gorm.Expr("node_global + ?", 1),
 		"mem_global":  gorm.Expr("mem_global + ?", mem),
 		"disk_global": gorm.Expr("disk_global + ?", disk),
@@ -52,10 +65,34 @@ func IncNode(mem, disk int64) error {
 	return nil
 }
 
+// decrease node resource when delnode
+func DecNode(mem, disk int64) error {
+	return decNode(GlobalDataBase, mem, disk)
+}
+
+// decrease node resource within db or a transaction
+func decNode(db *gorm.DB, mem, disk int64) error {
+	// 假设 Id 为 0 的记录是需要更新的记录
+	result := db.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
+		"node_global": gorm.Expr("node_global - ?", 1),
+		"mem_global":  gorm.Expr("mem_global - ?", mem),
+		"disk_global": gorm.Expr("disk_global - ?", disk),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
 // increase used resource when createorder
 func IncUsed(mem, disk int64) error {
+	return incUsed(GlobalDataBase, mem, disk)
+}
+
+// increase used resource within db or a transaction
+func incUsed(db *gorm.DB, mem, disk int64) error {
 	// 假设 Id 为 0 的记录是需要更新的记录
-	result := GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
+	result := db.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
 		"node_used": gorm.Expr("node_used + ?", 1),
 		"mem_used":  gorm.Expr("mem_used + ?", mem),
 		"disk_used": gorm.Expr("disk_used + ?", disk),
@@ -68,8 +105,13 @@ func IncUsed(mem, disk int64) error {
 
 // decrease used resource when order en
 func DecUsed(mem, disk int64) error {
+	return decUsed(GlobalDataBase, mem, disk)
+}
+
+// decrease used resource within db or a transaction
+func decUsed(db *gorm.DB, mem, disk int64) error {
 	// 假设 Id 为 0 的记录是需要更新的记录
-	result := GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
+	result := db.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
 		"node_used": gorm.Expr("node_used - ?", 1),
 		"mem_used":  gorm.Expr("mem_used - ?", mem),
 		"disk_used": gorm.Expr("disk_used - ?", disk),
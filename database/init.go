@@ -69,10 +69,31 @@ func InitDatabase(path string) error {
 		return err
 	}
 
+	// capacity table is built from nodes and orders when it is added to an old db
+	newCapacity := !db.Migrator().HasTable(&ProviderCapacity{})
+
 	// create all tables
-	db.AutoMigrate(&Order{}, &ProfitStore{}, &BlockNumber{}, &Provider{}, &NodeStore{}, &GlobalStore{})
+	db.AutoMigrate(&Order{}, &ProfitStore{}, &BlockNumber{}, &LogCursor{}, &Provider{}, &NodeStore{}, &GlobalStore{}, &Snapshot{}, &ProviderCapacity{}, &Settlement{}, &ProviderHistory{}, &ProbeRecord{}, &Outage{}, &Reputation{}, &Withdrawal{}, &BalanceChange{}, &Voucher{}, &BalanceRoot{}, &BalanceLeaf{}, &Entity{}, &UnhandledEvent{}, &DeadLetter{})
 	GlobalDataBase = db
 
+	// pad big int columns stored by old versions
+	err = migrateBigInt(db)
+	if err != nil {
+		return err
+	}
+
+	if newCapacity {
+		err = rebuildProviderCapacity(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = migrateOrderSettlement(db)
+	if err != nil {
+		return err
+	}
+
 	// insert a record for global
 	initialData := GlobalStore{
 		Id:         0,
@@ -83,6 +104,7 @@ func InitDatabase(path string) error {
 		DiskGlobal: 0,
 		MemUsed:    0,
 		DiskUsed:   0,
+		Maintained: true,
 	}
 	// 尝试查找记录
 	var existingData GlobalStore
@@ -96,6 +118,20 @@ func InitDatabase(path string) error {
 	} else if err != nil {
 		// 其他错误
 		panic("failed to check for existing data")
+	} else if !existingData.Maintained {
+		// old versions never updated the counters
+		err = db.Transaction(func(tx *gorm.DB) error {
+			drift, err := ReconcileGlobalTx(tx, true)
+			if err != nil {
+				return err
+			}
+			logger.Info("global counters repaired: ", drift.Repaired)
+
+			return tx.Model(&GlobalStore{}).Where("id = ?", 0).Update("maintained", true).Error
+		})
+		if err != nil {
+			return err
+		}
 	}
 
 	logger.Info("init database success")
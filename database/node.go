@@ -3,28 +3,28 @@ package database
 import (
 	"math/big"
 
-	"golang.org/x/xerrors"
+	"gorm.io/gorm"
 )
 
-type Node struct {
-	Address string
-	Id      uint64
+type NodeStore struct {
+	Address string `gorm:"primaryKey"`
+	Id      uint64 `gorm:"primaryKey;autoIncrement:false"`
 
-	CPUPriceMon *big.Int
-	CPUPriceSec *big.Int
+	CPUPriceMon *big.Int `gorm:"serializer:bigint"`
+	CPUPriceSec *big.Int `gorm:"serializer:bigint"`
 	CPUModel    string
 	CPUCore     uint64
 
-	GPUPriceMon *big.Int
-	GPUPriceSec *big.Int
+	GPUPriceMon *big.Int `gorm:"serializer:bigint"`
+	GPUPriceSec *big.Int `gorm:"serializer:bigint"`
 	GPUModel    string
 
-	MemPriceMon *big.Int
-	MemPriceSec *big.Int
+	MemPriceMon *big.Int `gorm:"serializer:bigint"`
+	MemPriceSec *big.Int `gorm:"serializer:bigint"`
 	MemCapacity int64
 
-	DiskPriceMon *big.Int
-	DiskPriceSec *big.Int
+	DiskPriceMon *big.Int `gorm:"serializer:bigint"`
+	DiskPriceSec *big.Int `gorm:"serializer:bigint"`
 	DiskCapacity int64
 
 	Exist bool
@@ -34,56 +34,74 @@ type Node struct {
 	Online bool
 }
 
-type NodeStore struct {
-	Address string `gorm:"primaryKey"`
-	Id      uint64 `gorm:"primaryKey;autoIncrement:false"`
-
-	CPUPriceMon string
-	CPUPriceSec string
-	CPUModel    string
-	CPUCore     uint64
-
-	GPUPriceMon string
-	GPUPriceSec string
-	GPUModel    string
+func InitNode() error {
+	return GlobalDataBase.AutoMigrate(&NodeStore{})
+}
 
-	MemPriceMon string
-	MemPriceSec string
-	MemCapacity int64
+// store node info to db, and accu node resource
+func (n *NodeStore) CreateNode() error {
+	return n.CreateNodeTx(GlobalDataBase)
+}
 
-	DiskPriceMon string
-	DiskPriceSec string
-	DiskCapacity int64
+// CreateNode within a transaction of the caller
+func (n *NodeStore) CreateNodeTx(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(n).Error
+		if err != nil {
+			return err
+		}
 
-	Exist bool
-	Sold  bool
-	Avail bool
+		err = incNode(tx, n.MemCapacity, n.DiskCapacity)
+		if err != nil {
+			return err
+		}
 
-	Online bool
+		return addNodeCapacity(tx, n.Address, 1, n.MemCapacity, n.DiskCapacity)
+	})
 }
 
-func InitNode() error {
-	return GlobalDataBase.AutoMigrate(&NodeStore{})
+// set an existing node not exist, and decrease node resource
+func DeleteNode(cp string, id uint64) error {
+	return DeleteNodeTx(GlobalDataBase, cp, id)
 }
 
-// store node info to db
-func (n *Node) CreateNode() error {
-	nodeStore, err := NodeToNodeStore(*n)
-	if err != nil {
-		return err
-	}
-	return GlobalDataBase.Create(&nodeStore).Error
+// DeleteNode within a transaction of the caller
+func DeleteNodeTx(db *gorm.DB, cp string, id uint64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var node NodeStore
+		err := tx.Model(&NodeStore{}).Where("address = ? AND id = ?", cp, id).First(&node).Error
+		if err != nil {
+			return err
+		}
+
+		// already deleted
+		if !node.Exist {
+			return nil
+		}
+
+		err = tx.Model(&NodeStore{}).Where("address = ? AND id = ?", cp, id).Update("exist", false).Error
+		if err != nil {
+			return err
+		}
+
+		err = decNode(tx, node.MemCapacity, node.DiskCapacity)
+		if err != nil {
+			return err
+		}
+
+		return addNodeCapacity(tx, cp, -1, -node.MemCapacity, -node.DiskCapacity)
+	})
 }
 
 // get node with cp and id
-func GetNodeByCpAndId(cp string, id uint64) (Node, error) {
+func GetNodeByCpAndId(cp string, id uint64) (NodeStore, error) {
 	var nodeStore NodeStore
 	err := GlobalDataBase.Model(&NodeStore{}).Where("address = ? AND id = ?", cp, id).First(&nodeStore).Error
 	if err != nil {
-		return Node{}, err
+		return NodeStore{}, err
 	}
 
-	return NodeStoreToNode(nodeStore)
+	return nodeStore, nil
 }
 
 // list all node by specify start and num of node
@@ -110,76 +128,16 @@ func ListAllNodesByCp(cp string) ([]NodeStore, error) {
 	return nodeStores, nil
 }
 
-// ListAllNodesByUser 通过用户地址查询与之相关的所有节点列表
+// ListAllNodesByUser 通过用户地址查询与之相关的所有节点列表, 每个订单对应一个节点
 func ListAllNodesByUser(user string) ([]NodeAdaptor, error) {
-	var nodes []NodeStore
-	var orders []Order
-
-	// 首先查询 orders 表，获取所有与用户相关的订单
-	err := GlobalDataBase.Where("user = ?", user).Find(&orders).Error
-	if err != nil {
-		return nil, err
-	}
-
-	// 然后根据 orders 表中的 provider 和 nid 查询 node_stores 表中的节点信息
-	var providers []string
-	var nids []int
-
-	for _, order := range orders {
-		providers = append(providers, order.Provider)
-		nids = append(nids, int(order.Nid))
-	}
-
-	err = GlobalDataBase.Model(&NodeStore{}).Where("address IN (?) AND id IN (?)", providers, nids).Find(&nodes).Error
+	deps, err := ListUserDeployments(user, DeploymentAll, 0, -1)
 	if err != nil {
 		return nil, err
 	}
 
 	nodeAdps := []NodeAdaptor{}
-	// 适配node到nodeInProvider
-	for _, n := range nodes {
-		// compatible node to node_in
-		node := NodeAdaptor{
-			ID: n.Id,
-			CP: n.Address,
-
-			CPU: CPU{
-				PriceMon: n.CPUPriceMon,
-				PriceSec: n.CPUPriceSec,
-				Model:    n.CPUModel,
-				Core:     n.CPUCore,
-			},
-			GPU: GPU{
-				PriceMon: n.GPUPriceMon,
-				PriceSec: n.GPUPriceSec,
-				Model:    n.GPUModel,
-			},
-			MEM: MEM{
-				PriceMon: n.MemPriceMon,
-				PriceSec: n.MemPriceSec,
-				Num:      n.MemCapacity,
-			},
-			DISK: DISK{
-				PriceMon: n.DiskPriceMon,
-				PriceSec: n.DiskPriceSec,
-				Num:      n.DiskCapacity,
-			},
-
-			Exist:  n.Exist,
-			Sold:   n.Sold,
-			Avail:  n.Avail,
-			Online: n.Online,
-		}
-
-		// get order's appname with provider and nid
-		var order Order
-		result := GlobalDataBase.Where("provider = ? AND nid = ?", node.CP, node.ID).First(&order)
-		if result.Error != nil {
-			return nil, result.Error
-		}
-		node.AppName = order.AppName
-
-		nodeAdps = append(nodeAdps, node)
+	for _, d := range deps {
+		nodeAdps = append(nodeAdps, d.Node)
 	}
 
 	return nodeAdps, nil
@@ -198,8 +156,13 @@ func SetExist(cp string, id uint64, set bool) error {
 
 // set node sold
 func SetSold(cp string, id uint64, set bool) error {
+	return SetSoldTx(GlobalDataBase, cp, id, set)
+}
+
+// SetSold within a transaction of the caller
+func SetSoldTx(db *gorm.DB, cp string, id uint64, set bool) error {
 	// 更新 node_stores 表中相应节点的 sold 字段
-	err := GlobalDataBase.Model(&NodeStore{}).Where("address = ? AND id = ?", cp, id).Update("sold", set).Error
+	err := db.Model(&NodeStore{}).Where("address = ? AND id = ?", cp, id).Update("sold", set).Error
 	if err != nil {
 		return err
 	}
@@ -229,99 +192,49 @@ func SetOnline(cp string, id uint64, set bool) error {
 	return nil
 }
 
-func NodeToNodeStore(node Node) (NodeStore, error) {
-	return NodeStore{
-		Address: node.Address,
-		Id:      node.Id,
-
-		CPUPriceMon: node.CPUPriceMon.String(),
-		CPUPriceSec: node.CPUPriceSec.String(),
-		CPUModel:    node.CPUModel,
-		CPUCore:     node.CPUCore,
-
-		GPUPriceMon: node.GPUPriceMon.String(),
-		GPUPriceSec: node.GPUPriceSec.String(),
-		GPUModel:    node.GPUModel,
-
-		MemPriceMon: node.MemPriceMon.String(),
-		MemPriceSec: node.MemPriceSec.String(),
-		MemCapacity: node.MemCapacity,
-
-		DiskPriceMon: node.DiskPriceMon.String(),
-		DiskPriceSec: node.DiskPriceSec.String(),
-		DiskCapacity: node.DiskCapacity,
-
-		Exist: node.Exist,
-		Sold:  node.Sold,
-		Avail: node.Avail,
-	}, nil
-}
-
-func NodeStoreToNode(node NodeStore) (Node, error) {
-	cpuPriceMon, ok := new(big.Int).SetString(node.CPUPriceMon, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.CPUPriceMon)
+// adapt node for json return
+func (n NodeStore) Adaptor() NodeAdaptor {
+	return NodeAdaptor{
+		ID: n.Id,
+		CP: n.Address,
+
+		CPU: CPU{
+			PriceMon: n.CPUPriceMon.String(),
+			PriceSec: n.CPUPriceSec.String(),
+			Model:    n.CPUModel,
+			Core:     n.CPUCore,
+		},
+		GPU: GPU{
+			PriceMon: n.GPUPriceMon.String(),
+			PriceSec: n.GPUPriceSec.String(),
+			Model:    n.GPUModel,
+		},
+		MEM: MEM{
+			PriceMon: n.MemPriceMon.String(),
+			PriceSec: n.MemPriceSec.String(),
+			Num:      n.MemCapacity,
+		},
+		DISK: DISK{
+			PriceMon: n.DiskPriceMon.String(),
+			PriceSec: n.DiskPriceSec.String(),
+			Num:      n.DiskCapacity,
+		},
+
+		Exist:  n.Exist,
+		Sold:   n.Sold,
+		Avail:  n.Avail,
+		Online: n.Online,
 	}
+}
 
-	cpuPriceSec, ok := new(big.Int).SetString(node.CPUPriceSec, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.CPUPriceSec)
-	}
-
-	gpuPriceMon, ok := new(big.Int).SetString(node.GPUPriceMon, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.GPUPriceMon)
-	}
-
-	gpuPriceSec, ok := new(big.Int).SetString(node.GPUPriceSec, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.GPUPriceMon)
-	}
-
-	memPriceMon, ok := new(big.Int).SetString(node.MemPriceMon, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.MemPriceMon)
-	}
-
-	memPriceSec, ok := new(big.Int).SetString(node.MemPriceSec, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.MemPriceSec)
-	}
-
-	diskPriceMon, ok := new(big.Int).SetString(node.DiskPriceMon, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.DiskPriceMon)
-	}
-
-	diskPriceSec, ok := new(big.Int).SetString(node.DiskPriceSec, 10)
-	if !ok {
-		return Node{}, xerrors.Errorf("Failed to convert %s to BigInt", node.DiskPriceSec)
-	}
-
-	return Node{
-		Address: node.Address,
-		Id:      node.Id,
-
-		CPUPriceMon: cpuPriceMon,
-		CPUPriceSec: cpuPriceSec,
-		CPUModel:    node.CPUModel,
-
-		GPUPriceMon: gpuPriceMon,
-		GPUPriceSec: gpuPriceSec,
-		GPUModel:    node.GPUModel,
-
-		MemPriceMon: memPriceMon,
-		MemPriceSec: memPriceSec,
-		MemCapacity: node.MemCapacity,
-
-		DiskPriceMon: diskPriceMon,
-		DiskPriceSec: diskPriceSec,
-		DiskCapacity: node.DiskCapacity,
+// price of the whole node per second
+func (n NodeStore) PriceSec() *big.Int {
+	memFeeSec := new(big.Int).Mul(new(big.Int).SetInt64(n.MemCapacity), n.MemPriceSec)
+	diskFeeSec := new(big.Int).Mul(new(big.Int).SetInt64(n.DiskCapacity), n.DiskPriceSec)
 
-		Exist: node.Exist,
-		Sold:  node.Sold,
-		Avail: node.Avail,
+	price := new(big.Int).Add(n.CPUPriceSec, n.GPUPriceSec)
+	price.Add(price, memFeeSec)
+	price.Add(price, diskFeeSec)
 
-		Online: node.Online,
-	}, nil
+	return price
 }
@@ -4,6 +4,17 @@ import (
 	"fmt"
 	"math/big"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// order status
+const (
+	OrderNotExist uint8 = iota
+	OrderUnactive
+	OrderActive
+	OrderCancelled
+	OrderCompleted
 )
 
 type Order struct {
@@ -18,15 +29,55 @@ type Order struct {
 	Duration     int64
 	Status       uint8
 	AppName      string
+
+	Price      *big.Int  `gorm:"serializer:bigint"` // node price per second
+	Remu       *big.Int  `gorm:"serializer:bigint"` // settled remuneration
+	LastSettle time.Time // end of the last settled period
 }
 
 func InitOrder() error {
 	return GlobalDataBase.AutoMigrate(&Order{})
 }
 
-// store order info to db
+// store order info to db, set the node sold and increase used resource
 func (o *Order) CreateOrder() error {
-	return GlobalDataBase.Create(o).Error
+	return o.CreateOrderTx(GlobalDataBase)
+}
+
+// CreateOrder within a transaction of the caller
+func (o *Order) CreateOrderTx(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var node NodeStore
+		err := tx.Model(&NodeStore{}).Where("address = ? AND id = ?", o.Provider, o.Nid).First(&node).Error
+		if err != nil {
+			return fmt.Errorf("error fetching node: %w", err)
+		}
+
+		// settlement starts from order start, or later if settled before being stored
+		o.Price = node.PriceSec()
+		o.Remu = big.NewInt(0)
+		if o.LastSettle.Before(o.StartTime) {
+			o.LastSettle = o.StartTime
+		}
+
+		err = tx.Create(o).Error
+		if err != nil {
+			return err
+		}
+
+		// set node sold=true
+		err = tx.Model(&NodeStore{}).Where("address = ? AND id = ?", o.Provider, o.Nid).Update("sold", true).Error
+		if err != nil {
+			return fmt.Errorf("error updating node: %w", err)
+		}
+
+		err = incUsed(tx, node.MemCapacity, node.DiskCapacity)
+		if err != nil {
+			return err
+		}
+
+		return addUsedCapacity(tx, o.Provider, 1, node.MemCapacity, node.DiskCapacity)
+	})
 }
 
 // get order by order id
@@ -40,6 +91,18 @@ func GetOrderById(id uint64) (Order, error) {
 	return order, nil
 }
 
+// list all orders by specify start and num of order
+func ListAllOrders(start, num int) ([]Order, error) {
+	var orders []Order
+
+	err := GlobalDataBase.Model(&Order{}).Order("id").Limit(num).Offset(start).Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
 // get order list of an user
 func GetOrdersByUser(user string) ([]Order, error) {
 	var orders []Order
@@ -101,21 +164,7 @@ func ListAllOrderByUser(address string) ([]OrderAdaptor, error) {
 	}
 
 	for _, o := range orders {
-		adp := OrderAdaptor{
-			ID:         o.Id,
-			User:       o.User,
-			Provider:   o.Provider,
-			Nid:        o.Nid,
-			AppName:    "",
-			Remain:     "",
-			Remu:       "",
-			ActiveTime: o.ActivateTime.Unix(),
-			LastSettle: 0,
-			Probation:  o.Probation,
-			Duration:   o.Duration,
-			Status:     o.Status,
-		}
-		ordersAdaptor = append(ordersAdaptor, adp)
+		ordersAdaptor = append(ordersAdaptor, o.Adaptor())
 	}
 
 	return ordersAdaptor, nil
@@ -132,26 +181,37 @@ func ListAllOrderByProvider(address string) ([]OrderAdaptor, error) {
 	}
 
 	for _, o := range orders {
-		adp := OrderAdaptor{
-			ID:         o.Id,
-			User:       o.User,
-			Provider:   o.Provider,
-			Nid:        o.Nid,
-			AppName:    "",
-			Remain:     "",
-			Remu:       "",
-			ActiveTime: o.ActivateTime.Unix(),
-			LastSettle: 0,
-			Probation:  o.Probation,
-			Duration:   o.Duration,
-			Status:     o.Status,
-		}
-		ordersAdaptor = append(ordersAdaptor, adp)
+		ordersAdaptor = append(ordersAdaptor, o.Adaptor())
 	}
 
 	return ordersAdaptor, nil
 }
 
+// adapt order for json return
+func (o Order) Adaptor() OrderAdaptor {
+	remain, remu := "", ""
+	if o.Price != nil && o.Remu != nil {
+		total := new(big.Int).Mul(o.Price, big.NewInt(o.Duration))
+		remain = total.Sub(total, o.Remu).String()
+		remu = o.Remu.String()
+	}
+
+	return OrderAdaptor{
+		ID:         o.Id,
+		User:       o.User,
+		Provider:   o.Provider,
+		Nid:        o.Nid,
+		AppName:    o.AppName,
+		Remain:     remain,
+		Remu:       remu,
+		ActiveTime: o.ActivateTime.Unix(),
+		LastSettle: o.LastSettle.Unix(),
+		Probation:  o.Probation,
+		Duration:   o.Duration,
+		Status:     o.Status,
+	}
+}
+
 // user's active orders
 func ListAllActivedOrderByUser(address string) ([]Order, error) {
 	var now = time.Now()
@@ -197,12 +257,7 @@ func CalcOrderFee(id uint64) (*big.Int, error) {
 	logger.Debug("node: ", node)
 
 	// calc order fee
-	memFeeSec := new(big.Int).Mul(new(big.Int).SetInt64(node.MemCapacity), node.MemPriceSec)
-	diskFeeSec := new(big.Int).Mul(new(big.Int).SetInt64(node.DiskCapacity), node.DiskPriceSec)
-
-	totalPrice := new(big.Int).Add(node.CPUPriceSec, node.GPUPriceSec)
-	totalPrice.Add(totalPrice, memFeeSec)
-	totalPrice.Add(totalPrice, diskFeeSec)
+	totalPrice := node.PriceSec()
 	totalPrice.Mul(totalPrice, new(big.Int).SetInt64(order.Duration))
 
 	// return order fee
@@ -273,3 +328,127 @@ func UpdateOrderAndNodeStatus(provider string) error {
 
 	return nil
 }
+
+// list all orders ended before t, which are not completed or cancelled yet
+func ListExpiredOrders(t time.Time) ([]Order, error) {
+	var orders []Order
+	err := GlobalDataBase.Model(&Order{}).
+		Where("end < ? AND status < ?", t, OrderCancelled).
+		Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// set an order cancelled at t, its settlement stops at t and its node and used resource are released.
+// return false if the order is already completed or cancelled
+func CancelOrder(id uint64, t time.Time) (bool, error) {
+	return CancelOrderTx(GlobalDataBase, id, t)
+}
+
+// CancelOrder within a transaction of the caller
+func CancelOrderTx(db *gorm.DB, id uint64, t time.Time) (bool, error) {
+	cancelled := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var o Order
+		err := tx.Model(&Order{}).Where("id = ?", id).First(&o).Error
+		if err != nil {
+			return err
+		}
+		if o.Status >= OrderCancelled {
+			return nil
+		}
+
+		// the order ends when cancelled, the remaining period is settled up to it
+		end := o.EndTime
+		if t.Before(end) {
+			end = t
+		}
+		if end.Before(o.LastSettle) {
+			end = o.LastSettle
+		}
+		err = tx.Model(&Order{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"status": OrderCancelled,
+			"end":    end,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("error updating order: %w", err)
+		}
+
+		err = releaseNode(tx, o)
+		if err != nil {
+			return err
+		}
+
+		cancelled = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return cancelled, nil
+}
+
+// set node of an order unsold and release the used resource
+func releaseNode(tx *gorm.DB, o Order) error {
+	var node NodeStore
+	err := tx.Model(&NodeStore{}).Where("address = ? AND id = ?", o.Provider, o.Nid).First(&node).Error
+	if err != nil {
+		return fmt.Errorf("error fetching node: %w", err)
+	}
+
+	// set node sold=false
+	err = tx.Model(&NodeStore{}).Where("address = ? AND id = ?", o.Provider, o.Nid).Update("sold", false).Error
+	if err != nil {
+		return fmt.Errorf("error updating node: %w", err)
+	}
+
+	// release used resource
+	err = decUsed(tx, node.MemCapacity, node.DiskCapacity)
+	if err != nil {
+		return fmt.Errorf("error updating global: %w", err)
+	}
+
+	err = addUsedCapacity(tx, o.Provider, -1, -node.MemCapacity, -node.DiskCapacity)
+	if err != nil {
+		return fmt.Errorf("error updating provider capacity: %w", err)
+	}
+
+	return nil
+}
+
+// set an ended order completed, release its node and the used resource.
+// return false if the order is already completed or cancelled
+func ExpireOrder(o Order) (bool, error) {
+	expired := false
+
+	err := GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		// set status=4 only once
+		result := tx.Model(&Order{}).
+			Where("id = ? AND status < ?", o.Id, OrderCancelled).
+			Update("status", OrderCompleted)
+		if result.Error != nil {
+			return fmt.Errorf("error updating order: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		err := releaseNode(tx, o)
+		if err != nil {
+			return err
+		}
+
+		expired = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return expired, nil
+}
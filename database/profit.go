@@ -4,7 +4,7 @@ import (
 	"math/big"
 	"time"
 
-	"golang.org/x/xerrors"
+	"gorm.io/gorm"
 )
 
 type Profit struct {
@@ -19,12 +19,13 @@ type Profit struct {
 }
 
 type ProfitStore struct {
-	Address  string    `gorm:"primarykey"` // CPU/GPU供应商ID
-	Balance  string    // 余额
-	Profit   string    // 分润值
-	Penalty  string    // 惩罚值
+	Address  string    `gorm:"primarykey"`        // CPU/GPU供应商ID
+	Balance  *big.Int  `gorm:"serializer:bigint"` // 余额
+	Profit   *big.Int  `gorm:"serializer:bigint"` // 分润值
+	Penalty  *big.Int  `gorm:"serializer:bigint"` // 惩罚值
 	LastTime time.Time // 上次更新时间
 	EndTime  time.Time // 可以取出全部分润值时间
+	Nonce    uint64    // 已索引的提现次数
 }
 
 func InitProfit() error {
@@ -34,57 +35,55 @@ func InitProfit() error {
 func (p *Profit) CreateProfit() error {
 	ps := &ProfitStore{
 		Address:  p.Address,
-		Balance:  p.Balance.String(),
-		Profit:   p.Profit.String(),
-		Penalty:  p.Penalty.String(),
+		Balance:  p.Balance,
+		Profit:   p.Profit,
+		Penalty:  p.Penalty,
 		LastTime: p.LastTime,
 		EndTime:  p.EndTime,
+		Nonce:    p.Nonce,
 	}
 	return GlobalDataBase.Create(ps).Error
 }
 
 func (p *Profit) UpdateProfit() error {
+	return p.UpdateProfitTx(GlobalDataBase)
+}
+
+// UpdateProfit within a transaction of the caller
+func (p *Profit) UpdateProfitTx(db *gorm.DB) error {
 	ps := &ProfitStore{
 		Address:  p.Address,
-		Balance:  p.Balance.String(),
-		Profit:   p.Profit.String(),
-		Penalty:  p.Penalty.String(),
+		Balance:  p.Balance,
+		Profit:   p.Profit,
+		Penalty:  p.Penalty,
 		LastTime: p.LastTime,
 		EndTime:  p.EndTime,
+		Nonce:    p.Nonce,
 	}
 
-	return GlobalDataBase.Model(&ProfitStore{}).Where("address = ?", p.Address).Save(ps).Error
+	return db.Model(&ProfitStore{}).Where("address = ?", p.Address).Save(ps).Error
 }
 
 func GetProfitByAddress(address string) (Profit, error) {
+	return GetProfitByAddressTx(GlobalDataBase, address)
+}
+
+// GetProfitByAddress within a transaction of the caller
+func GetProfitByAddressTx(db *gorm.DB, address string) (Profit, error) {
 	var ps ProfitStore
-	err := GlobalDataBase.Model(&ProfitStore{}).Where("address = ?", address).First(&ps).Error
+	err := db.Model(&ProfitStore{}).Where("address = ?", address).First(&ps).Error
 	if err != nil {
 		return Profit{}, err
 	}
 
 	var profit = Profit{
-		Balance:  big.NewInt(0),
-		Profit:   big.NewInt(0),
-		Penalty:  big.NewInt(0),
+		Balance:  ps.Balance,
+		Profit:   ps.Profit,
+		Penalty:  ps.Penalty,
 		Address:  ps.Address,
 		LastTime: ps.LastTime,
 		EndTime:  ps.EndTime,
-	}
-
-	_, ok := profit.Balance.SetString(ps.Balance, 10)
-	if !ok {
-		return Profit{}, xerrors.Errorf("Balance %s is not in decimal format", ps.Balance)
-	}
-
-	_, ok = profit.Profit.SetString(ps.Profit, 10)
-	if !ok {
-		return Profit{}, xerrors.Errorf("Profit %s is not in decimal format", ps.Profit)
-	}
-
-	_, ok = profit.Penalty.SetString(ps.Penalty, 10)
-	if !ok {
-		return Profit{}, xerrors.Errorf("Penalty %s is not in decimal format", ps.Penalty)
+		Nonce:    ps.Nonce,
 	}
 
 	return profit, nil
@@ -98,11 +97,16 @@ type BlockNumber struct {
 }
 
 func SetBlockNumber(blockNumber int64) error {
+	return SetBlockNumberTx(GlobalDataBase, blockNumber)
+}
+
+// SetBlockNumber within a transaction of the caller
+func SetBlockNumberTx(db *gorm.DB, blockNumber int64) error {
 	var daBlockNumber = BlockNumber{
 		BlockNumberKey: blockNumberKey,
 		BlockNumber:    blockNumber,
 	}
-	return GlobalDataBase.Save(&daBlockNumber).Error
+	return db.Save(&daBlockNumber).Error
 }
 
 func GetBlockNumber() (int64, error) {
@@ -111,3 +115,44 @@ func GetBlockNumber() (int64, error) {
 
 	return blockNumber.BlockNumber, err
 }
+
+var logCursorKey = "log_cursor_key"
+
+// position of the last applied log, logs up to it are not applied again when their block is fetched again
+type LogCursor struct {
+	CursorKey   string `gorm:"primarykey"`
+	BlockNumber uint64
+	LogIndex    uint
+}
+
+func SetLogCursor(blockNumber uint64, logIndex uint) error {
+	return SetLogCursorTx(GlobalDataBase, blockNumber, logIndex)
+}
+
+// SetLogCursor within a transaction of the caller, the cursor only moves forward
+func SetLogCursorTx(db *gorm.DB, blockNumber uint64, logIndex uint) error {
+	var cursors []LogCursor
+	err := db.Model(&LogCursor{}).Where("cursor_key = ?", logCursorKey).Find(&cursors).Error
+	if err != nil {
+		return err
+	}
+	if len(cursors) > 0 {
+		c := cursors[0]
+		if c.BlockNumber > blockNumber || (c.BlockNumber == blockNumber && c.LogIndex >= logIndex) {
+			return nil
+		}
+	}
+
+	return db.Save(&LogCursor{CursorKey: logCursorKey, BlockNumber: blockNumber, LogIndex: logIndex}).Error
+}
+
+// position of the last applied log, false if no log is applied
+func GetLogCursor() (uint64, uint, bool, error) {
+	var cursors []LogCursor
+	err := GlobalDataBase.Model(&LogCursor{}).Where("cursor_key = ?", logCursorKey).Find(&cursors).Error
+	if err != nil || len(cursors) == 0 {
+		return 0, 0, false, err
+	}
+
+	return cursors[0].BlockNumber, cursors[0].LogIndex, true, nil
+}
@@ -1,5 +1,13 @@
 package database
 
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+)
+
 type Provider struct {
 	Address string `gorm:"primarykey"`
 	Name    string
@@ -60,171 +68,219 @@ type ProviderAdaptor struct {
 	NDisk uint64 `json:"nDisk"`
 	UDisk uint64 `json:"uDisk"`
 
+	Reputation float64 `json:"reputation"` // latest score, 0 if never computed
+
 	Nodes []NodeAdaptor `json:"nodes"`
 }
 
+// provider info registered at a block
+type ProviderHistory struct {
+	Id          uint64 `gorm:"primaryKey" json:"id"`
+	Address     string `gorm:"index" json:"address"`
+	Name        string `json:"name"`
+	IP          string `json:"ip"`
+	Domain      string `json:"domain"`
+	Port        string `json:"port"`
+	BlockNumber uint64 `json:"blockNumber"`
+	TxHash      string `json:"txHash"`
+}
+
 func InitProvider() error {
-	return GlobalDataBase.AutoMigrate(&Provider{})
+	return GlobalDataBase.AutoMigrate(&Provider{}, &ProviderHistory{})
 }
 
-// store provider info to db
+// store provider info to db, and increase the cp number
 func (p *Provider) CreateProvider() error {
-	return GlobalDataBase.Create(p).Error
+	return GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(p).Error
+		if err != nil {
+			return err
+		}
+
+		return incCp(tx)
+	})
 }
 
-// get cp info
-func GetProviderByAddress(address string) (ProviderAdaptor, error) {
-	var provider Provider
+// store a registered provider, or update its info if it registers again.
+// a new provider increases the cp number and gets an empty profit record,
+// an existing profit record is never reset. every change is kept in history.
+// return true if the provider is new
+func (p *Provider) UpsertProvider(blockNumber uint64, txHash string) (bool, error) {
+	return p.UpsertProviderTx(GlobalDataBase, blockNumber, txHash)
+}
+
+// UpsertProvider within a transaction of the caller
+func (p *Provider) UpsertProviderTx(db *gorm.DB, blockNumber uint64, txHash string) (bool, error) {
+	created := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var old Provider
+		err := tx.Model(&Provider{}).Where("address = ?", p.Address).First(&old).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			err = tx.Create(p).Error
+			if err != nil {
+				return err
+			}
+
+			err = incCp(tx)
+			if err != nil {
+				return err
+			}
 
-	// load provider
-	err := GlobalDataBase.Model(&Provider{}).Where("address = ?", address).First(&provider).Error
+			created = true
+		case err != nil:
+			return err
+		case old == *p:
+			// nothing changed
+			return nil
+		default:
+			err = tx.Model(&Provider{}).Where("address = ?", p.Address).Updates(map[string]interface{}{
+				"name":   p.Name,
+				"ip":     p.IP,
+				"domain": p.Domain,
+				"port":   p.Port,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// keep profit of a registered provider
+		now := time.Now()
+		err = tx.Where(ProfitStore{Address: p.Address}).FirstOrCreate(&ProfitStore{
+			Address:  p.Address,
+			Balance:  big.NewInt(0),
+			Profit:   big.NewInt(0),
+			Penalty:  big.NewInt(0),
+			LastTime: now,
+			EndTime:  now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&ProviderHistory{
+			Address:     p.Address,
+			Name:        p.Name,
+			IP:          p.IP,
+			Domain:      p.Domain,
+			Port:        p.Port,
+			BlockNumber: blockNumber,
+			TxHash:      txHash,
+		}).Error
+	})
 	if err != nil {
-		return ProviderAdaptor{}, err
+		return false, err
 	}
 
-	// adapt provider
-	var nodes []NodeStore
-	// load nodes
-	err = GlobalDataBase.Where("address = ?", provider.Address).Find(&nodes).Error
+	return created, nil
+}
+
+// registration history of a provider, oldest first
+func ListProviderHistory(address string) ([]ProviderHistory, error) {
+	var history []ProviderHistory
+	err := GlobalDataBase.Model(&ProviderHistory{}).Where("address = ?", address).Order("id").Find(&history).Error
 	if err != nil {
-		return ProviderAdaptor{}, err
+		return nil, err
 	}
 
-	nodes_in := []NodeAdaptor{}
-	// 适配node到nodeInProvider
-	for _, n := range nodes {
-		// compatible node to node_in
-		node_in := NodeAdaptor{
-			ID: n.Id,
-			CP: n.Address,
-
-			CPU: CPU{
-				PriceMon: n.CPUPriceMon,
-				PriceSec: n.CPUPriceSec,
-				Model:    n.CPUModel,
-				Core:     n.CPUCore,
-			},
-			GPU: GPU{
-				PriceMon: n.GPUPriceMon,
-				PriceSec: n.GPUPriceSec,
-				Model:    n.GPUModel,
-			},
-			MEM: MEM{
-				PriceMon: n.MemPriceMon,
-				PriceSec: n.MemPriceSec,
-				Num:      n.MemCapacity,
-			},
-			DISK: DISK{
-				PriceMon: n.DiskPriceMon,
-				PriceSec: n.DiskPriceSec,
-				Num:      n.DiskCapacity,
-			},
-
-			Exist:  n.Exist,
-			Sold:   n.Sold,
-			Avail:  n.Avail,
-			Online: n.Online,
-		}
+	return history, nil
+}
+
+// get cp info
+func GetProviderByAddress(address string) (ProviderAdaptor, error) {
+	var rows []providerRow
 
-		nodes_in = append(nodes_in, node_in)
+	// load provider with its capacity
+	err := providersWithCapacity().
+		Where("providers.address = ?", address).
+		Limit(1).
+		Scan(&rows).Error
+	if err != nil {
+		return ProviderAdaptor{}, err
+	}
+	if len(rows) == 0 {
+		return ProviderAdaptor{}, gorm.ErrRecordNotFound
 	}
 
-	// 将Provider和其Node列表添加到新的数据结构中
-	providerAdp := ProviderAdaptor{
-		Address: provider.Address,
-		Name:    provider.Name,
-		IP:      provider.IP,
-		Domain:  provider.Domain,
-		Port:    provider.Port,
-
-		NNode: 0,
-		UNode: 0,
-		NMem:  0,
-		UMem:  0,
-		NDisk: 0,
-		UDisk: 0,
-
-		Nodes: nodes_in,
+	// adapt provider
+	providerAdps, err := adaptProviders(rows)
+	if err != nil {
+		return ProviderAdaptor{}, err
 	}
 
-	return providerAdp, nil
+	return providerAdps[0], nil
 }
 
 // list all providers with nodes
 func ListAllProviders(start int, num int) ([]ProviderAdaptor, error) {
-	var providers []Provider
-	var providersWithNodes []ProviderAdaptor
+	return ListProviders(ProviderQuery{Start: start, Num: num})
+}
 
-	// 获取Provider列表
-	err := GlobalDataBase.Model(&Provider{}).Limit(num).Offset(start).Find(&providers).Error
-	if err != nil {
-		return nil, err
-	}
+// provider joined with its capacity
+type providerRow struct {
+	Provider `gorm:"embedded"`
+
+	NNode int64
+	UNode int64
+	NMem  int64
+	UMem  int64
+	NDisk int64
+	UDisk int64
+}
 
-	// 为每个Provider获取对应的Node列表
-	for _, provider := range providers {
-		var nodes []NodeStore
+// adapt providers and load their nodes in one query
+func adaptProviders(rows []providerRow) ([]ProviderAdaptor, error) {
+	var addresses []string
+	for _, r := range rows {
+		addresses = append(addresses, r.Address)
+	}
 
-		err = GlobalDataBase.Where("address = ?", provider.Address).Find(&nodes).Error
+	var nodes []NodeStore
+	if len(addresses) > 0 {
+		err := GlobalDataBase.Where("address IN ?", addresses).Order("address, id").Find(&nodes).Error
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		var nodes_in []NodeAdaptor
-		// 适配node到nodeInProvider
-		for _, n := range nodes {
-			// compatible node to node_in
-			node_in := NodeAdaptor{
-				ID: n.Id,
-				CP: n.Address,
-
-				CPU: CPU{
-					PriceMon: n.CPUPriceMon,
-					PriceSec: n.CPUPriceSec,
-					Model:    n.CPUModel,
-					Core:     n.CPUCore,
-				},
-				GPU: GPU{
-					PriceMon: n.GPUPriceMon,
-					PriceSec: n.GPUPriceSec,
-					Model:    n.GPUModel,
-				},
-				MEM: MEM{
-					PriceMon: n.MemPriceMon,
-					PriceSec: n.MemPriceSec,
-					Num:      n.MemCapacity,
-				},
-				DISK: DISK{
-					PriceMon: n.DiskPriceMon,
-					PriceSec: n.DiskPriceSec,
-					Num:      n.DiskCapacity,
-				},
-
-				Exist:  n.Exist,
-				Sold:   n.Sold,
-				Avail:  n.Avail,
-				Online: n.Online,
-			}
+	reps, err := GetLatestReputations(addresses)
+	if err != nil {
+		return nil, err
+	}
 
-			nodes_in = append(nodes_in, node_in)
+	// 适配node到nodeInProvider
+	nodes_in := make(map[string][]NodeAdaptor)
+	for _, n := range nodes {
+		nodes_in[n.Address] = append(nodes_in[n.Address], n.Adaptor())
+	}
+
+	// 将Provider和其Node列表添加到新的数据结构中
+	providersWithNodes := []ProviderAdaptor{}
+	for _, r := range rows {
+		nodes := nodes_in[r.Address]
+		if nodes == nil {
+			nodes = []NodeAdaptor{}
 		}
 
-		// 将Provider和其Node列表添加到新的数据结构中
 		providersWithNodes = append(providersWithNodes, ProviderAdaptor{
-			Address: provider.Address,
-			Name:    provider.Name,
-			IP:      provider.IP,
-			Domain:  provider.Domain,
-			Port:    provider.Port,
-
-			NNode: 0,
-			UNode: 0,
-			NMem:  0,
-			UMem:  0,
-			NDisk: 0,
-			UDisk: 0,
-
-			Nodes: nodes_in,
+			Address: r.Address,
+			Name:    r.Name,
+			IP:      r.IP,
+			Domain:  r.Domain,
+			Port:    r.Port,
+
+			NNode: uint64(r.NNode),
+			UNode: uint64(r.UNode),
+			NMem:  uint64(r.NMem),
+			UMem:  uint64(r.UMem),
+			NDisk: uint64(r.NDisk),
+			UDisk: uint64(r.UDisk),
+
+			Reputation: reps[r.Address].Score,
+
+			Nodes: nodes,
 		})
 	}
 
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"gorm.io/gorm"
+)
+
+// GlobalDrift holds the counters stored in GlobalStore and the ones recomputed from base tables
+type GlobalDrift struct {
+	Stored   GlobalStore `json:"stored"`
+	Computed GlobalStore `json:"computed"`
+	Repaired bool        `json:"repaired"`
+}
+
+// check if any counter is different
+func (d GlobalDrift) HasDrift() bool {
+	s, c := d.Stored, d.Computed
+	return s.CpNum != c.CpNum ||
+		s.NodeGlobal != c.NodeGlobal ||
+		s.NodeUsed != c.NodeUsed ||
+		s.MemGlobal != c.MemGlobal ||
+		s.DiskGlobal != c.DiskGlobal ||
+		s.MemUsed != c.MemUsed ||
+		s.DiskUsed != c.DiskUsed
+}
+
+// recompute global counters from providers, nodes and orders
+func ComputeGlobal() (GlobalStore, error) {
+	return computeGlobal(GlobalDataBase)
+}
+
+func computeGlobal(db *gorm.DB) (GlobalStore, error) {
+	var g GlobalStore
+
+	// cp number
+	if err := db.Model(&Provider{}).Count(&g.CpNum).Error; err != nil {
+		return GlobalStore{}, err
+	}
+
+	// existing nodes
+	var total struct {
+		NodeGlobal int64
+		MemGlobal  int64
+		DiskGlobal int64
+	}
+	if err := db.Model(&NodeStore{}).
+		Select("COUNT(*) AS node_global, COALESCE(SUM(mem_capacity), 0) AS mem_global, COALESCE(SUM(disk_capacity), 0) AS disk_global").
+		Where("exist = ?", true).
+		Scan(&total).Error; err != nil {
+		return GlobalStore{}, err
+	}
+
+	// nodes in orders not completed or cancelled
+	var used struct {
+		NodeUsed int64
+		MemUsed  int64
+		DiskUsed int64
+	}
+	if err := db.Model(&Order{}).
+		Select("COUNT(*) AS node_used, COALESCE(SUM(ns.mem_capacity), 0) AS mem_used, COALESCE(SUM(ns.disk_capacity), 0) AS disk_used").
+		Joins("JOIN node_stores ns ON ns.address = orders.provider AND ns.id = orders.nid").
+		Where("orders.status < ?", OrderCancelled).
+		Scan(&used).Error; err != nil {
+		return GlobalStore{}, err
+	}
+
+	g.NodeGlobal = total.NodeGlobal
+	g.MemGlobal = total.MemGlobal
+	g.DiskGlobal = total.DiskGlobal
+	g.NodeUsed = used.NodeUsed
+	g.MemUsed = used.MemUsed
+	g.DiskUsed = used.DiskUsed
+
+	return g, nil
+}
+
+// compare stored global counters with the computed ones, overwrite the stored counters if repair is set.
+// counters are read and repaired in one transaction, so concurrent handlers are not overwritten
+func ReconcileGlobal(repair bool) (GlobalDrift, error) {
+	var drift GlobalDrift
+	err := GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		var err error
+		drift, err = reconcileGlobal(tx, repair)
+		return err
+	})
+	if err != nil {
+		return GlobalDrift{}, err
+	}
+
+	return drift, nil
+}
+
+// ReconcileGlobal within a transaction of the caller
+func ReconcileGlobalTx(db *gorm.DB, repair bool) (GlobalDrift, error) {
+	return reconcileGlobal(db, repair)
+}
+
+func reconcileGlobal(db *gorm.DB, repair bool) (GlobalDrift, error) {
+	var drift GlobalDrift
+
+	err := db.Model(&GlobalStore{}).Where("id = ?", 0).First(&drift.Stored).Error
+	if err != nil {
+		return GlobalDrift{}, err
+	}
+
+	drift.Computed, err = computeGlobal(db)
+	if err != nil {
+		return GlobalDrift{}, err
+	}
+
+	if !repair || !drift.HasDrift() {
+		return drift, nil
+	}
+
+	err = db.Model(&GlobalStore{}).Where("id = ?", 0).UpdateColumns(map[string]interface{}{
+		"cp_num":      drift.Computed.CpNum,
+		"node_global": drift.Computed.NodeGlobal,
+		"node_used":   drift.Computed.NodeUsed,
+		"mem_global":  drift.Computed.MemGlobal,
+		"disk_global": drift.Computed.DiskGlobal,
+		"mem_used":    drift.Computed.MemUsed,
+		"disk_used":   drift.Computed.DiskUsed,
+	}).Error
+	if err != nil {
+		return GlobalDrift{}, err
+	}
+	drift.Repaired = true
+
+	return drift, nil
+}
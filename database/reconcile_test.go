@@ -0,0 +1,65 @@
+package database
+
+import (
+	"testing"
+)
+
+func getGlobal(t *testing.T) GlobalStore {
+	var g GlobalStore
+	err := GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).First(&g).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestMigrateGlobal(t *testing.T) {
+	dir := t.TempDir()
+	err := InitDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := NodeStore{Address: "0x1111111111111111111111111111111111111111", Id: 1, MemCapacity: 2, DiskCapacity: 3, Exist: true}
+	err = node.CreateNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := getGlobal(t)
+	if !g.Maintained || g.NodeGlobal != 1 || g.MemGlobal != 2 || g.DiskGlobal != 3 {
+		t.Fatalf("unexpected counters %+v", g)
+	}
+
+	// the zero row of an old version is repaired on open
+	err = GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).
+		Updates(map[string]interface{}{"node_global": 0, "mem_global": 0, "disk_global": 0, "maintained": false}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g = getGlobal(t)
+	if !g.Maintained || g.NodeGlobal != 1 || g.MemGlobal != 2 || g.DiskGlobal != 3 {
+		t.Fatalf("counters not repaired %+v", g)
+	}
+
+	// only once, later drift is left to the reconciler
+	err = GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).Update("node_global", 5).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g = getGlobal(t); g.NodeGlobal != 5 {
+		t.Fatalf("counters repaired again %+v", g)
+	}
+
+	drift, err := ReconcileGlobal(true)
+	if err != nil || !drift.Repaired || drift.Stored.NodeGlobal != 5 || drift.Computed.NodeGlobal != 1 {
+		t.Fatalf("reconcile %+v: %v", drift, err)
+	}
+}
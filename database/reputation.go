@@ -0,0 +1,194 @@
+package database
+
+import (
+	"math/big"
+	"time"
+)
+
+// weights of the reputation components, the score is their weighted average.
+// each component is in [0, 1] and a component without data counts as 1:
+//
+//	Completion: completed / (completed + cancelled) orders
+//	Penalty:    1 - penalty / (profit + penalty)
+//	Uptime:     uptime of the last 30 days since the first probe
+//	Churn:      1 - deleted nodes / all nodes
+type ReputationWeights struct {
+	Completion float64 `json:"completion"`
+	Penalty    float64 `json:"penalty"`
+	Uptime     float64 `json:"uptime"`
+	Churn      float64 `json:"churn"`
+}
+
+func DefaultReputationWeights() ReputationWeights {
+	return ReputationWeights{
+		Completion: 0.4,
+		Penalty:    0.2,
+		Uptime:     0.3,
+		Churn:      0.1,
+	}
+}
+
+// reputation of a provider at a time
+type Reputation struct {
+	Id      uint64    `gorm:"primaryKey" json:"id"`
+	Address string    `gorm:"index" json:"address"`
+	Time    time.Time `gorm:"index" json:"time"`
+	Score   float64   `json:"score"` // 0-100
+
+	Completion float64 `json:"completion"`
+	Penalty    float64 `json:"penalty"`
+	Uptime     float64 `json:"uptime"`
+	Churn      float64 `json:"churn"`
+}
+
+func InitReputation() error {
+	return GlobalDataBase.AutoMigrate(&Reputation{})
+}
+
+// compute the reputation of a provider at now
+func ComputeReputation(address string, now time.Time, w ReputationWeights) (Reputation, error) {
+	r := Reputation{
+		Address:    address,
+		Time:       now,
+		Completion: 1,
+		Penalty:    1,
+		Uptime:     1,
+		Churn:      1,
+	}
+
+	// completed vs cancelled orders
+	var orders struct {
+		Completed int64
+		Cancelled int64
+	}
+	err := GlobalDataBase.Model(&Order{}).
+		Select("COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0) AS completed, "+
+			"COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0) AS cancelled", OrderCompleted, OrderCancelled).
+		Where("provider = ?", address).
+		Scan(&orders).Error
+	if err != nil {
+		return Reputation{}, err
+	}
+	if finished := orders.Completed + orders.Cancelled; finished > 0 {
+		r.Completion = float64(orders.Completed) / float64(finished)
+	}
+
+	// penalty against profit
+	var profit ProfitStore
+	err = GlobalDataBase.Model(&ProfitStore{}).Where("address = ?", address).Find(&profit).Error
+	if err != nil {
+		return Reputation{}, err
+	}
+	if profit.Profit != nil && profit.Penalty != nil && profit.Penalty.Sign() > 0 {
+		total := new(big.Int).Add(profit.Profit, profit.Penalty)
+		ratio, _ := new(big.Rat).SetFrac(profit.Penalty, total).Float64()
+		r.Penalty = 1 - ratio
+	}
+
+	// uptime of probed provider. probes are in wall clock time while now may be the time
+	// of an old block during catch up, a window before the first probe has no data
+	var probes int64
+	err = GlobalDataBase.Model(&ProbeRecord{}).Where("address = ? AND time < ?", address, now).Count(&probes).Error
+	if err != nil {
+		return Reputation{}, err
+	}
+	if probes > 0 {
+		up, err := GetProviderUptime(address, now.Add(-30*24*time.Hour), now)
+		if err != nil {
+			return Reputation{}, err
+		}
+		r.Uptime = up / 100
+	}
+
+	// deleted nodes
+	var nodes struct {
+		Total   int64
+		Deleted int64
+	}
+	err = GlobalDataBase.Model(&NodeStore{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(CASE WHEN exist THEN 0 ELSE 1 END), 0) AS deleted").
+		Where("address = ?", address).
+		Scan(&nodes).Error
+	if err != nil {
+		return Reputation{}, err
+	}
+	if nodes.Total > 0 {
+		r.Churn = 1 - float64(nodes.Deleted)/float64(nodes.Total)
+	}
+
+	sum := w.Completion + w.Penalty + w.Uptime + w.Churn
+	if sum > 0 {
+		r.Score = 100 * (w.Completion*r.Completion + w.Penalty*r.Penalty + w.Uptime*r.Uptime + w.Churn*r.Churn) / sum
+	}
+
+	return r, nil
+}
+
+// compute and store reputations of all providers at now
+func UpdateReputations(now time.Time, w ReputationWeights) error {
+	providers, err := ListProviderEndpoints()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range providers {
+		r, err := ComputeReputation(p.Address, now, w)
+		if err != nil {
+			return err
+		}
+
+		err = GlobalDataBase.Create(&r).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// latest reputation of providers, keyed by address
+func GetLatestReputations(addresses []string) (map[string]Reputation, error) {
+	reps := make(map[string]Reputation)
+	if len(addresses) == 0 {
+		return reps, nil
+	}
+
+	var rows []Reputation
+	err := GlobalDataBase.Model(&Reputation{}).
+		Where("id IN (?)", GlobalDataBase.Model(&Reputation{}).Select("MAX(id)").Where("address IN ?", addresses).Group("address")).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		reps[r.Address] = r
+	}
+
+	return reps, nil
+}
+
+// reputation history of a provider in [from, to]
+func ListReputationHistory(address string, from, to time.Time) ([]Reputation, error) {
+	var reps []Reputation
+	err := GlobalDataBase.Model(&Reputation{}).
+		Where("address = ? AND time >= ? AND time <= ?", address, from, to).
+		Order("time").
+		Find(&reps).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return reps, nil
+}
+
+// time of the latest stored reputation, zero if none
+func GetLastReputationTime() (time.Time, error) {
+	var r Reputation
+	err := GlobalDataBase.Model(&Reputation{}).Order("time DESC").Limit(1).Find(&r).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return r.Time, nil
+}
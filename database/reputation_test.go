@@ -0,0 +1,63 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReputationUptime(t *testing.T) {
+	err := InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := "0x1111111111111111111111111111111111111111"
+	probed := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		r := ProbeRecord{Address: address, Time: probed.Add(time.Duration(i) * time.Hour), Success: true, Online: true}
+		err = r.CreateProbeRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// offline for the second hour of probing
+	err = SetProviderStatus(address, false, probed.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetProviderStatus(address, true, probed.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := ReputationWeights{Uptime: 1}
+
+	// block times of catching up are before any probe
+	for _, now := range []time.Time{probed.Add(-time.Hour), probed} {
+		r, err := ComputeReputation(address, now, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Uptime != 1 || r.Score != 100 {
+			t.Fatalf("uptime %v score %v at %v before probing", r.Uptime, r.Score, now)
+		}
+	}
+
+	r, err := ComputeReputation(address, probed.Add(4*time.Hour), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Uptime != 0.75 || r.Score != 75 {
+		t.Fatalf("uptime %v score %v, want 0.75", r.Uptime, r.Score)
+	}
+
+	// a provider never probed
+	r, err = ComputeReputation("0x2222222222222222222222222222222222222222", probed, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Uptime != 1 {
+		t.Fatalf("uptime %v without probes", r.Uptime)
+	}
+}
@@ -0,0 +1,364 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/xerrors"
+)
+
+// sortable columns of node search
+const (
+	SortCPUPriceSec  = "cpu_price_sec"
+	SortCPUPriceMon  = "cpu_price_mon"
+	SortGPUPriceSec  = "gpu_price_sec"
+	SortGPUPriceMon  = "gpu_price_mon"
+	SortMemPriceSec  = "mem_price_sec"
+	SortMemPriceMon  = "mem_price_mon"
+	SortDiskPriceSec = "disk_price_sec"
+	SortDiskPriceMon = "disk_price_mon"
+	SortCPUCore      = "cpu_core"
+	SortMemCapacity  = "mem_capacity"
+	SortDiskCapacity = "disk_capacity"
+)
+
+// price columns are stored in the padded form of BigIntSerializer
+var priceColumns = map[string]func(n NodeStore) *big.Int{
+	SortCPUPriceSec:  func(n NodeStore) *big.Int { return n.CPUPriceSec },
+	SortCPUPriceMon:  func(n NodeStore) *big.Int { return n.CPUPriceMon },
+	SortGPUPriceSec:  func(n NodeStore) *big.Int { return n.GPUPriceSec },
+	SortGPUPriceMon:  func(n NodeStore) *big.Int { return n.GPUPriceMon },
+	SortMemPriceSec:  func(n NodeStore) *big.Int { return n.MemPriceSec },
+	SortMemPriceMon:  func(n NodeStore) *big.Int { return n.MemPriceMon },
+	SortDiskPriceSec: func(n NodeStore) *big.Int { return n.DiskPriceSec },
+	SortDiskPriceMon: func(n NodeStore) *big.Int { return n.DiskPriceMon },
+}
+
+var capacityColumns = map[string]func(n NodeStore) int64{
+	SortCPUCore:      func(n NodeStore) int64 { return int64(n.CPUCore) },
+	SortMemCapacity:  func(n NodeStore) int64 { return n.MemCapacity },
+	SortDiskCapacity: func(n NodeStore) int64 { return n.DiskCapacity },
+}
+
+// NodeQuery builds a node search, the zero limit returns all matched nodes
+type NodeQuery struct {
+	conds  []nodeCond
+	sort   string
+	desc   bool
+	limit  int
+	cursor string
+	err    error
+}
+
+type nodeCond struct {
+	query string
+	args  []interface{}
+}
+
+// position after the last returned node
+type nodeCursor struct {
+	Value   string `json:"v"`
+	Address string `json:"a"`
+	Id      uint64 `json:"i"`
+}
+
+// new node query sorted by address and id
+func NewNodeQuery() *NodeQuery {
+	return &NodeQuery{}
+}
+
+func (q *NodeQuery) where(query string, args ...interface{}) *NodeQuery {
+	q.conds = append(q.conds, nodeCond{query: query, args: args})
+	return q
+}
+
+// nodes of a provider
+func (q *NodeQuery) Provider(address string) *NodeQuery {
+	return q.where("address = ?", address)
+}
+
+func (q *NodeQuery) CPUModel(model string) *NodeQuery {
+	return q.where("cpu_model = ?", model)
+}
+
+func (q *NodeQuery) MinCPUCore(core uint64) *NodeQuery {
+	return q.where("cpu_core >= ?", core)
+}
+
+func (q *NodeQuery) GPUModel(model string) *NodeQuery {
+	return q.where("gpu_model = ?", model)
+}
+
+func (q *NodeQuery) MinMem(mem int64) *NodeQuery {
+	return q.where("mem_capacity >= ?", mem)
+}
+
+func (q *NodeQuery) MinDisk(disk int64) *NodeQuery {
+	return q.where("disk_capacity >= ?", disk)
+}
+
+// price of column is no more than max
+func (q *NodeQuery) MaxPrice(column string, max *big.Int) *NodeQuery {
+	if _, ok := priceColumns[column]; !ok {
+		q.err = xerrors.Errorf("%s is not a price column", column)
+		return q
+	}
+
+	return q.where(column+" <= ?", EncodeBigInt(max))
+}
+
+func (q *NodeQuery) Avail(set bool) *NodeQuery {
+	return q.where("avail = ?", set)
+}
+
+func (q *NodeQuery) Sold(set bool) *NodeQuery {
+	return q.where("sold = ?", set)
+}
+
+func (q *NodeQuery) Exist(set bool) *NodeQuery {
+	return q.where("exist = ?", set)
+}
+
+func (q *NodeQuery) Online(set bool) *NodeQuery {
+	return q.where("online = ?", set)
+}
+
+// sort by a price or capacity column, ties are ordered by address and id
+func (q *NodeQuery) SortBy(column string, desc bool) *NodeQuery {
+	_, isPrice := priceColumns[column]
+	_, isCapacity := capacityColumns[column]
+	if !isPrice && !isCapacity {
+		q.err = xerrors.Errorf("can not sort by %s", column)
+		return q
+	}
+
+	q.sort = column
+	q.desc = desc
+	return q
+}
+
+// max number of nodes in a page
+func (q *NodeQuery) Limit(num int) *NodeQuery {
+	q.limit = num
+	return q
+}
+
+// continue after the cursor returned by the previous page
+func (q *NodeQuery) After(cursor string) *NodeQuery {
+	q.cursor = cursor
+	return q
+}
+
+// sort expressions of the query, the last two are always address and id.
+// a nil price is stored as NULL and sorted as an empty string, before all prices
+func (q *NodeQuery) sortExprs() []string {
+	if _, ok := priceColumns[q.sort]; ok {
+		return []string{"COALESCE(" + q.sort + ", '')", "address", "id"}
+	}
+	if q.sort != "" {
+		return []string{q.sort, "address", "id"}
+	}
+	return []string{"address", "id"}
+}
+
+// values of sort expressions at a cursor
+func (q *NodeQuery) cursorArgs(c nodeCursor) ([]interface{}, error) {
+	if _, ok := priceColumns[q.sort]; ok {
+		return []interface{}{c.Value, c.Address, c.Id}, nil
+	}
+	if q.sort != "" {
+		v, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid cursor value %s: %w", c.Value, err)
+		}
+		return []interface{}{v, c.Address, c.Id}, nil
+	}
+	return []interface{}{c.Address, c.Id}, nil
+}
+
+// make the cursor after node n
+func (q *NodeQuery) cursorOf(n NodeStore) (string, error) {
+	c := nodeCursor{Address: n.Address, Id: n.Id}
+	if get, ok := priceColumns[q.sort]; ok {
+		if v := get(n); v != nil {
+			c.Value = EncodeBigInt(v)
+		}
+	} else if get, ok := capacityColumns[q.sort]; ok {
+		c.Value = strconv.FormatInt(get(n), 10)
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// run the query, return matched nodes and the cursor of next page, which is empty on the last page
+func (q *NodeQuery) Find() ([]NodeStore, string, error) {
+	if q.err != nil {
+		return nil, "", q.err
+	}
+
+	db := GlobalDataBase.Model(&NodeStore{})
+	for _, c := range q.conds {
+		db = db.Where(c.query, c.args...)
+	}
+
+	exprs := q.sortExprs()
+	dir := "ASC"
+	op := ">"
+	if q.desc {
+		dir = "DESC"
+		op = "<"
+	}
+
+	// keyset pagination
+	if q.cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(q.cursor)
+		if err != nil {
+			return nil, "", xerrors.Errorf("invalid cursor: %w", err)
+		}
+		var c nodeCursor
+		err = json.Unmarshal(b, &c)
+		if err != nil {
+			return nil, "", xerrors.Errorf("invalid cursor: %w", err)
+		}
+
+		args, err := q.cursorArgs(c)
+		if err != nil {
+			return nil, "", err
+		}
+
+		lhs, rhs := "", ""
+		for i, e := range exprs {
+			if i > 0 {
+				lhs += ", "
+				rhs += ", "
+			}
+			lhs += e
+			rhs += "?"
+		}
+		db = db.Where(fmt.Sprintf("(%s) %s (%s)", lhs, op, rhs), args...)
+	}
+
+	for _, e := range exprs {
+		db = db.Order(e + " " + dir)
+	}
+	if q.limit > 0 {
+		db = db.Limit(q.limit)
+	}
+
+	var nodes []NodeStore
+	err := db.Find(&nodes).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	// no more page
+	if q.limit == 0 || len(nodes) < q.limit {
+		return nodes, "", nil
+	}
+
+	next, err := q.cursorOf(nodes[len(nodes)-1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return nodes, next, nil
+}
+
+// build a node query from url parameters, used by http handlers:
+// provider, cpuModel, minCore, gpuModel, minMem, minDisk,
+// max_<price column> (e.g. max_cpu_price_sec), avail, sold, exist, online,
+// sort, desc, limit, cursor
+func ParseNodeQuery(values url.Values) (*NodeQuery, error) {
+	q := NewNodeQuery()
+
+	if v := values.Get("provider"); v != "" {
+		q.Provider(v)
+	}
+	if v := values.Get("cpuModel"); v != "" {
+		q.CPUModel(v)
+	}
+	if v := values.Get("gpuModel"); v != "" {
+		q.GPUModel(v)
+	}
+
+	if v := values.Get("minCore"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid minCore %s: %w", v, err)
+		}
+		q.MinCPUCore(n)
+	}
+	if v := values.Get("minMem"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid minMem %s: %w", v, err)
+		}
+		q.MinMem(n)
+	}
+	if v := values.Get("minDisk"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid minDisk %s: %w", v, err)
+		}
+		q.MinDisk(n)
+	}
+
+	for column := range priceColumns {
+		v := values.Get("max_" + column)
+		if v == "" {
+			continue
+		}
+		max, ok := new(big.Int).SetString(v, 10)
+		if !ok {
+			return nil, xerrors.Errorf("Failed to convert %s to BigInt", v)
+		}
+		q.MaxPrice(column, max)
+	}
+
+	flags := []struct {
+		name string
+		set  func(bool) *NodeQuery
+	}{
+		{"avail", q.Avail},
+		{"sold", q.Sold},
+		{"exist", q.Exist},
+		{"online", q.Online},
+	}
+	for _, f := range flags {
+		v := values.Get(f.name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid %s %s: %w", f.name, v, err)
+		}
+		f.set(b)
+	}
+
+	if v := values.Get("sort"); v != "" {
+		desc, _ := strconv.ParseBool(values.Get("desc"))
+		q.SortBy(v, desc)
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid limit %s: %w", v, err)
+		}
+		q.Limit(n)
+	}
+
+	q.After(values.Get("cursor"))
+
+	return q, q.err
+}
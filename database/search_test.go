@@ -0,0 +1,194 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+	"net/url"
+	"testing"
+)
+
+// nodes of two providers with cpu prices 5, 1, nil, 3, 1, 100 and capacities 0-5
+func testNodes(t *testing.T) {
+	err := InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prices := []*big.Int{big.NewInt(5), big.NewInt(1), nil, big.NewInt(3), big.NewInt(1), big.NewInt(100)}
+	for i, price := range prices {
+		n := NodeStore{
+			Address:     fmt.Sprintf("0x%040d", i%2+1),
+			Id:          uint64(i),
+			CPUPriceSec: price,
+			CPUModel:    "cpu",
+			MemCapacity: int64(i),
+			Exist:       true,
+			Avail:       i != 5,
+		}
+		err = n.CreateNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// all pages of a query, as node ids
+func pages(t *testing.T, q func() *NodeQuery, limit int) []uint64 {
+	var ids []uint64
+	cursor := ""
+	for i := 0; i < 10; i++ {
+		nodes, next, err := q().Limit(limit).After(cursor).Find()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) > limit {
+			t.Fatalf("%d nodes in a page of %d", len(nodes), limit)
+		}
+		for _, n := range nodes {
+			ids = append(ids, n.Id)
+		}
+		if next == "" {
+			return ids
+		}
+		cursor = next
+	}
+
+	t.Fatal("pagination does not end")
+	return nil
+}
+
+func equalIds(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNodeQueryPages(t *testing.T) {
+	testNodes(t)
+
+	cases := []struct {
+		name string
+		q    func() *NodeQuery
+		want []uint64
+	}{
+		{"address", NewNodeQuery, []uint64{0, 2, 4, 1, 3, 5}},
+		// the nil price is first, equal prices by address and id
+		{"price", func() *NodeQuery { return NewNodeQuery().SortBy(SortCPUPriceSec, false) }, []uint64{2, 4, 1, 3, 0, 5}},
+		{"price desc", func() *NodeQuery { return NewNodeQuery().SortBy(SortCPUPriceSec, true) }, []uint64{5, 0, 3, 1, 4, 2}},
+		{"capacity desc", func() *NodeQuery { return NewNodeQuery().SortBy(SortMemCapacity, true) }, []uint64{5, 4, 3, 2, 1, 0}},
+		{"filtered", func() *NodeQuery {
+			return NewNodeQuery().Avail(true).MaxPrice(SortCPUPriceSec, big.NewInt(3)).SortBy(SortCPUPriceSec, false)
+		}, []uint64{4, 1, 3}},
+	}
+
+	for _, c := range cases {
+		all, next, err := c.q().Find()
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if next != "" {
+			t.Fatalf("%s: cursor without limit", c.name)
+		}
+		var ids []uint64
+		for _, n := range all {
+			ids = append(ids, n.Id)
+		}
+		if !equalIds(ids, c.want) {
+			t.Fatalf("%s: got %v, want %v", c.name, ids, c.want)
+		}
+
+		for _, limit := range []int{1, 2, 4} {
+			ids := pages(t, c.q, limit)
+			if !equalIds(ids, c.want) {
+				t.Fatalf("%s: pages of %d got %v, want %v", c.name, limit, ids, c.want)
+			}
+		}
+	}
+}
+
+func TestNodeQueryErrors(t *testing.T) {
+	testNodes(t)
+
+	_, _, err := NewNodeQuery().SortBy("cpu_model", false).Find()
+	if err == nil {
+		t.Fatal("sorted by a column that is not sortable")
+	}
+	_, _, err = NewNodeQuery().MaxPrice(SortMemCapacity, big.NewInt(1)).Find()
+	if err == nil {
+		t.Fatal("max price of a capacity column")
+	}
+	_, _, err = NewNodeQuery().Limit(1).After("not a cursor").Find()
+	if err == nil {
+		t.Fatal("invalid cursor accepted")
+	}
+
+}
+
+func TestParseNodeQuery(t *testing.T) {
+	testNodes(t)
+
+	q, err := ParseNodeQuery(url.Values{
+		"provider":          {fmt.Sprintf("0x%040d", 1)},
+		"cpuModel":          {"cpu"},
+		"minMem":            {"1"},
+		"max_cpu_price_sec": {"10"},
+		"avail":             {"true"},
+		"sort":              {SortCPUPriceSec},
+		"desc":              {"true"},
+		"limit":             {"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, cursor, err := q.Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Id != 4 || cursor == "" {
+		t.Fatalf("unexpected page %+v %s", nodes, cursor)
+	}
+
+	q, err = ParseNodeQuery(url.Values{
+		"provider":          {fmt.Sprintf("0x%040d", 1)},
+		"cpuModel":          {"cpu"},
+		"minMem":            {"1"},
+		"max_cpu_price_sec": {"10"},
+		"avail":             {"true"},
+		"sort":              {SortCPUPriceSec},
+		"desc":              {"true"},
+		"limit":             {"1"},
+		"cursor":            {cursor},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, cursor, err = q.Find()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the node without a price is not below the max
+	if len(nodes) != 0 || cursor != "" {
+		t.Fatalf("unexpected last page %+v %s", nodes, cursor)
+	}
+
+	for _, values := range []url.Values{
+		{"minCore": {"-1"}},
+		{"minMem": {"x"}},
+		{"minDisk": {"1.5"}},
+		{"max_gpu_price_mon": {"cheap"}},
+		{"online": {"yes"}},
+		{"sort": {"name"}},
+		{"limit": {"all"}},
+	} {
+		_, err := ParseNodeQuery(values)
+		if err == nil {
+			t.Fatalf("parsed invalid query %v", values)
+		}
+	}
+}
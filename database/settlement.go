@@ -0,0 +1,175 @@
+package database
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// remuneration accrued by an order in a period
+type Settlement struct {
+	Id          uint64    `gorm:"primaryKey" json:"id"`
+	OrderId     uint64    `gorm:"index" json:"orderId"`
+	Provider    string    `gorm:"index" json:"provider"`
+	Amount      *big.Int  `gorm:"serializer:bigint" json:"amount"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	BlockNumber uint64    `json:"blockNumber"`
+}
+
+func InitSettlement() error {
+	return GlobalDataBase.AutoMigrate(&Settlement{})
+}
+
+// list orders to settle at t: started, not completed, not settled up to their end,
+// and last settled before t-interval or ended before t.
+// a cancelled order ends at its cancel time and gets its final period
+func ListSettleableOrders(t time.Time, interval time.Duration) ([]Order, error) {
+	var orders []Order
+	err := GlobalDataBase.Model(&Order{}).
+		Where("status <= ? AND start < ? AND last_settle < end", OrderCancelled, t).
+		Where("last_settle <= ? OR end <= ?", t.Add(-interval), t).
+		Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// accrue the remuneration of an order from its last settle time to t, capped by the order end,
+// and credit it to the provider balance.
+// return false if nothing to settle
+func SettleOrder(o Order, t time.Time, blockNumber uint64) (Settlement, bool, error) {
+	from := o.LastSettle
+	if from.Before(o.StartTime) {
+		from = o.StartTime
+	}
+	to := t
+	if to.After(o.EndTime) {
+		to = o.EndTime
+	}
+
+	seconds := int64(to.Sub(from).Seconds())
+	if o.Price == nil || seconds <= 0 {
+		return Settlement{}, false, nil
+	}
+
+	s := Settlement{
+		OrderId:     o.Id,
+		Provider:    o.Provider,
+		Amount:      new(big.Int).Mul(o.Price, big.NewInt(seconds)),
+		From:        from,
+		To:          from.Add(time.Duration(seconds) * time.Second),
+		BlockNumber: blockNumber,
+	}
+
+	remu := new(big.Int).Add(o.Remu, s.Amount)
+
+	err := GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		// the order may be settled by others since it was loaded
+		result := tx.Model(&Order{}).
+			Where("id = ? AND last_settle = ?", o.Id, o.LastSettle).
+			Updates(map[string]interface{}{
+				"remu":        EncodeBigInt(remu),
+				"last_settle": s.To,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		err := tx.Create(&s).Error
+		if err != nil {
+			return err
+		}
+
+		// settled remuneration is claimable by the provider
+		var ps ProfitStore
+		err = tx.Where(ProfitStore{Address: o.Provider}).Attrs(ProfitStore{
+			Balance:  big.NewInt(0),
+			Profit:   big.NewInt(0),
+			Penalty:  big.NewInt(0),
+			LastTime: t,
+			EndTime:  t,
+		}).FirstOrCreate(&ps).Error
+		if err != nil {
+			return err
+		}
+
+		balance := new(big.Int).Set(s.Amount)
+		if ps.Balance != nil {
+			balance.Add(balance, ps.Balance)
+		}
+		err = tx.Model(&ProfitStore{}).Where("address = ?", o.Provider).Update("balance", EncodeBigInt(balance)).Error
+		if err != nil {
+			return err
+		}
+
+		return addBalanceChange(tx, BalanceChange{
+			Provider:    o.Provider,
+			Kind:        BalanceSettle,
+			Delta:       s.Amount,
+			Balance:     balance,
+			BlockNumber: blockNumber,
+		})
+	})
+	if err == gorm.ErrRecordNotFound {
+		return Settlement{}, false, nil
+	}
+	if err != nil {
+		return Settlement{}, false, err
+	}
+
+	return s, true, nil
+}
+
+// settlement history of an order
+func ListSettlementsByOrder(id uint64) ([]Settlement, error) {
+	var settlements []Settlement
+	err := GlobalDataBase.Model(&Settlement{}).Where("order_id = ?", id).Order("id").Find(&settlements).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return settlements, nil
+}
+
+// fill settlement fields of orders stored by old versions
+func migrateOrderSettlement(db *gorm.DB) error {
+	var orders []Order
+	err := db.Model(&Order{}).Where("price IS NULL").Find(&orders).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, o := range orders {
+			// old versions stored orders without checking the node, they are never settled
+			var node NodeStore
+			err := tx.Model(&NodeStore{}).Where("address = ? AND id = ?", o.Provider, o.Nid).First(&node).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				logger.Warnw("order without node is not settled", "order", o.Id, "provider", o.Provider, "node", o.Nid)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&Order{}).Where("id = ?", o.Id).Updates(map[string]interface{}{
+				"price":       EncodeBigInt(node.PriceSec()),
+				"remu":        EncodeBigInt(big.NewInt(0)),
+				"last_settle": o.StartTime,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// a node priced 3 per second and an order on it from start to end
+func testOrder(t *testing.T, start, end time.Time) Order {
+	err := InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one, zero := big.NewInt(1), big.NewInt(0)
+	node := NodeStore{
+		Address:     "0x1111111111111111111111111111111111111111",
+		Id:          1,
+		CPUPriceMon: zero, CPUPriceSec: one,
+		GPUPriceMon: zero, GPUPriceSec: one,
+		MemPriceMon: zero, MemPriceSec: one, MemCapacity: 1,
+		DiskPriceMon: zero, DiskPriceSec: zero, DiskCapacity: 10,
+		Exist: true,
+	}
+	err = node.CreateNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := Order{
+		Id:        7,
+		User:      "0x2222222222222222222222222222222222222222",
+		Provider:  node.Address,
+		Nid:       node.Id,
+		StartTime: start,
+		EndTime:   end,
+		Status:    OrderActive,
+	}
+	err = o.CreateOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return o
+}
+
+func testBalance(t *testing.T, address string) *big.Int {
+	amount, _, err := GetWithdrawable(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+func TestSettleOrder(t *testing.T) {
+	start := time.Unix(1000, 0)
+	o := testOrder(t, start, start.Add(100*time.Second))
+	if o.Price.Cmp(big.NewInt(3)) != 0 || !o.LastSettle.Equal(start) {
+		t.Fatalf("price %s last settle %v", o.Price, o.LastSettle)
+	}
+
+	// nothing due before the interval passed
+	orders, err := ListSettleableOrders(start.Add(10*time.Second), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 0 {
+		t.Fatalf("%d orders due", len(orders))
+	}
+
+	orders, err = ListSettleableOrders(start.Add(time.Minute), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("%d orders due", len(orders))
+	}
+
+	s, ok, err := SettleOrder(orders[0], start.Add(time.Minute), 10)
+	if err != nil || !ok {
+		t.Fatal("settle: ", ok, err)
+	}
+	if s.Amount.Cmp(big.NewInt(180)) != 0 || !s.To.Equal(start.Add(time.Minute)) {
+		t.Fatalf("settled %s up to %v", s.Amount, s.To)
+	}
+	if b := testBalance(t, o.Provider); b.Cmp(big.NewInt(180)) != 0 {
+		t.Fatalf("balance %s", b)
+	}
+
+	// the final period is capped by the order end
+	o, err = GetOrderById(o.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok, err = SettleOrder(o, start.Add(time.Hour), 11)
+	if err != nil || !ok {
+		t.Fatal("settle: ", ok, err)
+	}
+	if s.Amount.Cmp(big.NewInt(120)) != 0 || !s.To.Equal(o.EndTime) {
+		t.Fatalf("settled %s up to %v", s.Amount, s.To)
+	}
+
+	orders, err = ListSettleableOrders(start.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 0 {
+		t.Fatal("a settled order is due again")
+	}
+
+	settlements, err := ListSettlementsByOrder(o.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settlements) != 2 {
+		t.Fatalf("%d settlements", len(settlements))
+	}
+	if b := testBalance(t, o.Provider); b.Cmp(big.NewInt(300)) != 0 {
+		t.Fatalf("balance %s", b)
+	}
+}
+
+func TestSettleOrderStale(t *testing.T) {
+	start := time.Unix(1000, 0)
+	o := testOrder(t, start, start.Add(100*time.Second))
+
+	_, ok, err := SettleOrder(o, start.Add(10*time.Second), 10)
+	if err != nil || !ok {
+		t.Fatal("settle: ", ok, err)
+	}
+
+	// the loaded order is stale, last_settle moved since
+	_, ok, err = SettleOrder(o, start.Add(20*time.Second), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("a stale order is settled twice")
+	}
+
+	settlements, err := ListSettlementsByOrder(o.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settlements) != 1 {
+		t.Fatalf("%d settlements", len(settlements))
+	}
+	if b := testBalance(t, o.Provider); b.Cmp(big.NewInt(30)) != 0 {
+		t.Fatalf("balance %s", b)
+	}
+}
+
+func TestSettleCancelledOrder(t *testing.T) {
+	start := time.Unix(1000, 0)
+	o := testOrder(t, start, start.Add(100*time.Second))
+
+	cancelled, err := CancelOrder(o.Id, start.Add(40*time.Second))
+	if err != nil || !cancelled {
+		t.Fatal("cancel: ", cancelled, err)
+	}
+	cancelled, err = CancelOrder(o.Id, start.Add(50*time.Second))
+	if err != nil || cancelled {
+		t.Fatal("cancelled twice: ", cancelled, err)
+	}
+
+	node, err := GetNodeByCpAndId(o.Provider, o.Nid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Sold {
+		t.Fatal("node of a cancelled order is sold")
+	}
+
+	// the period up to the cancel time is still settled, nothing after
+	orders, err := ListSettleableOrders(start.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("%d orders due", len(orders))
+	}
+	s, ok, err := SettleOrder(orders[0], start.Add(time.Hour), 10)
+	if err != nil || !ok {
+		t.Fatal("settle: ", ok, err)
+	}
+	if s.Amount.Cmp(big.NewInt(120)) != 0 {
+		t.Fatalf("settled %s after cancel", s.Amount)
+	}
+
+	orders, err = ListSettleableOrders(start.Add(2*time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 0 {
+		t.Fatal("a cancelled order is due again")
+	}
+	if b := testBalance(t, o.Provider); b.Cmp(big.NewInt(120)) != 0 {
+		t.Fatalf("balance %s", b)
+	}
+}
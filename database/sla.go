@@ -0,0 +1,224 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a period when a provider is offline, End is nil while it lasts
+type Outage struct {
+	Id      uint64     `gorm:"primaryKey" json:"id"`
+	Address string     `gorm:"index" json:"address"`
+	Start   time.Time  `gorm:"index" json:"start"`
+	End     *time.Time `json:"end"`
+}
+
+// uptime percentages of rolling windows
+type Uptime struct {
+	Day   float64 `json:"day"`
+	Week  float64 `json:"week"`
+	Month float64 `json:"month"`
+}
+
+// downtime of an order caused by provider outages
+type OrderSLA struct {
+	OrderId  uint64    `json:"orderId"`
+	User     string    `json:"user"`
+	Provider string    `json:"provider"`
+	Nid      uint64    `json:"nodeId"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`      // order end, or the report time if the order is running
+	Downtime int64     `json:"downtime"` // seconds
+	Uptime   float64   `json:"uptime"`   // percentage in [start, end]
+	Outages  []Outage  `json:"outages"`
+}
+
+func InitOutage() error {
+	return GlobalDataBase.AutoMigrate(&Outage{})
+}
+
+// set online of all nodes of a provider at t, and open or close its outage
+func SetProviderStatus(address string, online bool, t time.Time) error {
+	return GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&NodeStore{}).Where("address = ?", address).Update("online", online).Error
+		if err != nil {
+			return err
+		}
+
+		// close the ongoing outage
+		if online {
+			return tx.Model(&Outage{}).Where("address = ? AND end IS NULL", address).Update("end", t).Error
+		}
+
+		// open an outage if none is ongoing
+		var cnt int64
+		err = tx.Model(&Outage{}).Where("address = ? AND end IS NULL", address).Count(&cnt).Error
+		if err != nil {
+			return err
+		}
+		if cnt > 0 {
+			return nil
+		}
+
+		return tx.Create(&Outage{Address: address, Start: t}).Error
+	})
+}
+
+// list outages of a provider overlapping [from, to]
+func ListOutages(address string, from, to time.Time) ([]Outage, error) {
+	var outages []Outage
+	err := GlobalDataBase.Model(&Outage{}).
+		Where("address = ? AND start < ? AND (end IS NULL OR end > ?)", address, to, from).
+		Order("start").
+		Find(&outages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return outages, nil
+}
+
+// total seconds of outages within [from, to]
+func downtime(outages []Outage, from, to time.Time) int64 {
+	var total time.Duration
+	for _, o := range outages {
+		start, end := o.Start, to
+		if o.End != nil && o.End.Before(end) {
+			end = *o.End
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+
+	return int64(total.Seconds())
+}
+
+// uptime percentage of a provider in [from, to].
+// the window starts at the first probe of the provider, it is 0 if never probed
+func GetProviderUptime(address string, from, to time.Time) (float64, error) {
+	var first ProbeRecord
+	err := GlobalDataBase.Model(&ProbeRecord{}).Where("address = ?", address).Order("time").Limit(1).Find(&first).Error
+	if err != nil {
+		return 0, err
+	}
+	if first.Id == 0 || !first.Time.Before(to) {
+		return 0, nil
+	}
+	if first.Time.After(from) {
+		from = first.Time
+	}
+
+	outages, err := ListOutages(address, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(to.Sub(from).Seconds())
+	if total <= 0 {
+		return 0, nil
+	}
+
+	return 100 * float64(total-downtime(outages, from, to)) / float64(total), nil
+}
+
+// uptime of a provider in the last 24h, 7d and 30d before now
+func GetProviderUptimeWindows(address string, now time.Time) (Uptime, error) {
+	var up Uptime
+	var err error
+
+	up.Day, err = GetProviderUptime(address, now.Add(-24*time.Hour), now)
+	if err != nil {
+		return Uptime{}, err
+	}
+	up.Week, err = GetProviderUptime(address, now.Add(-7*24*time.Hour), now)
+	if err != nil {
+		return Uptime{}, err
+	}
+	up.Month, err = GetProviderUptime(address, now.Add(-30*24*time.Hour), now)
+	if err != nil {
+		return Uptime{}, err
+	}
+
+	return up, nil
+}
+
+// uptime of a node in the last 24h, 7d and 30d.
+// nodes are online with their provider endpoint, so they share the provider outages
+func GetNodeUptimeWindows(cp string, id uint64, now time.Time) (Uptime, error) {
+	_, err := GetNodeByCpAndId(cp, id)
+	if err != nil {
+		return Uptime{}, err
+	}
+
+	return GetProviderUptimeWindows(cp, now)
+}
+
+// sla of running orders of a provider at now
+func ProviderSLAReport(address string, now time.Time) ([]OrderSLA, error) {
+	var orders []Order
+	err := GlobalDataBase.Model(&Order{}).
+		Where("provider = ? AND status < ? AND start < ?", address, OrderCancelled, now).
+		Order("id").
+		Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return reportSLA(orders, now)
+}
+
+// sla of all orders of a user at now
+func UserSLAReport(user string, now time.Time) ([]OrderSLA, error) {
+	var orders []Order
+	err := GlobalDataBase.Model(&Order{}).
+		Where("user = ? AND start < ?", user, now).
+		Order("id").
+		Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return reportSLA(orders, now)
+}
+
+// overlay provider outages on orders
+func reportSLA(orders []Order, now time.Time) ([]OrderSLA, error) {
+	reports := []OrderSLA{}
+	for _, o := range orders {
+		end := o.EndTime
+		if end.After(now) {
+			end = now
+		}
+
+		outages, err := ListOutages(o.Provider, o.StartTime, end)
+		if err != nil {
+			return nil, err
+		}
+
+		sla := OrderSLA{
+			OrderId:  o.Id,
+			User:     o.User,
+			Provider: o.Provider,
+			Nid:      o.Nid,
+			Start:    o.StartTime,
+			End:      end,
+			Downtime: downtime(outages, o.StartTime, end),
+			Uptime:   100,
+			Outages:  outages,
+		}
+
+		total := int64(end.Sub(o.StartTime).Seconds())
+		if total > 0 {
+			sla.Uptime = 100 * float64(total-sla.Downtime) / float64(total)
+		}
+
+		reports = append(reports, sla)
+	}
+
+	return reports, nil
+}
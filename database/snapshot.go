@@ -0,0 +1,200 @@
+package database
+
+import (
+	"math/big"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// market utilization at a block
+type Snapshot struct {
+	Id          uint64    `gorm:"primaryKey" json:"id"`
+	BlockNumber uint64    `gorm:"index" json:"blockNumber"`
+	Time        time.Time `gorm:"index" json:"time"` // block time
+
+	CpNum      int64 `json:"cpNumber"`
+	NodeGlobal int64 `json:"nodeGlobal"`
+	NodeUsed   int64 `json:"nodeUsed"`
+	MemGlobal  int64 `json:"memGlobal"`
+	MemUsed    int64 `json:"memUsed"`
+	DiskGlobal int64 `json:"diskGlobal"`
+	DiskUsed   int64 `json:"diskUsed"`
+
+	OrderValue string `json:"orderValue"` // total value of all orders
+}
+
+// resolution of snapshot series
+type Resolution string
+
+const (
+	ResolutionRaw  Resolution = "raw"
+	ResolutionHour Resolution = "hour"
+	ResolutionDay  Resolution = "day"
+	ResolutionWeek Resolution = "week"
+)
+
+// bucket length of a resolution, 0 for raw
+func (r Resolution) Duration() (time.Duration, error) {
+	switch r {
+	case ResolutionRaw, "":
+		return 0, nil
+	case ResolutionHour:
+		return time.Hour, nil
+	case ResolutionDay:
+		return 24 * time.Hour, nil
+	case ResolutionWeek:
+		return 7 * 24 * time.Hour, nil
+	default:
+		return 0, xerrors.Errorf("unknown resolution %s", r)
+	}
+}
+
+func InitSnapshot() error {
+	return GlobalDataBase.AutoMigrate(&Snapshot{})
+}
+
+// record current global counters and order value at a block
+func TakeSnapshot(blockNumber uint64, t time.Time) (Snapshot, error) {
+	var g GlobalStore
+	err := GlobalDataBase.Model(&GlobalStore{}).Where("id = ?", 0).First(&g).Error
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	value, err := GetTotalOrderValue()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	s := Snapshot{
+		BlockNumber: blockNumber,
+		Time:        t,
+
+		CpNum:      g.CpNum,
+		NodeGlobal: g.NodeGlobal,
+		NodeUsed:   g.NodeUsed,
+		MemGlobal:  g.MemGlobal,
+		MemUsed:    g.MemUsed,
+		DiskGlobal: g.DiskGlobal,
+		DiskUsed:   g.DiskUsed,
+
+		OrderValue: value.String(),
+	}
+
+	err = GlobalDataBase.Create(&s).Error
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return s, nil
+}
+
+// get the latest snapshot
+func GetLastSnapshot() (Snapshot, error) {
+	var s Snapshot
+	err := GlobalDataBase.Model(&Snapshot{}).Order("block_number DESC").First(&s).Error
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return s, nil
+}
+
+// list snapshots in [from, to], only the last snapshot of each bucket is kept for a coarse resolution
+func ListSnapshots(from, to time.Time, res Resolution) ([]Snapshot, error) {
+	bucket, err := res.Duration()
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps []Snapshot
+	err = GlobalDataBase.Model(&Snapshot{}).
+		Where("time >= ? AND time <= ?", from, to).
+		Order("time, block_number").
+		Find(&snaps).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return downsample(snaps, bucket), nil
+}
+
+// remove snapshots before t which are not the last of their bucket, return the number removed
+func CompactSnapshots(before time.Time, res Resolution) (int64, error) {
+	bucket, err := res.Duration()
+	if err != nil {
+		return 0, err
+	}
+	if bucket == 0 {
+		return 0, nil
+	}
+
+	var snaps []Snapshot
+	err = GlobalDataBase.Model(&Snapshot{}).
+		Select("id, block_number, time").
+		Where("time < ?", before).
+		Order("time, block_number").
+		Find(&snaps).Error
+	if err != nil {
+		return 0, err
+	}
+
+	keep := make(map[uint64]bool)
+	for _, s := range downsample(snaps, bucket) {
+		keep[s.Id] = true
+	}
+
+	var ids []uint64
+	for _, s := range snaps {
+		if !keep[s.Id] {
+			ids = append(ids, s.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result := GlobalDataBase.Where("id IN ?", ids).Delete(&Snapshot{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// keep the last one of each bucket, snaps must be sorted by time
+func downsample(snaps []Snapshot, bucket time.Duration) []Snapshot {
+	if bucket == 0 {
+		return snaps
+	}
+
+	var out []Snapshot
+	for i, s := range snaps {
+		if i+1 < len(snaps) && snaps[i+1].Time.Truncate(bucket).Equal(s.Time.Truncate(bucket)) {
+			continue
+		}
+		out = append(out, s)
+	}
+
+	return out
+}
+
+// sum of price * duration of all orders
+func GetTotalOrderValue() (*big.Int, error) {
+	var orders []Order
+	err := GlobalDataBase.Model(&Order{}).Select("price, duration").Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	total := new(big.Int)
+	for _, o := range orders {
+		if o.Price == nil {
+			continue
+		}
+		total.Add(total, new(big.Int).Mul(o.Price, big.NewInt(o.Duration)))
+	}
+
+	return total, nil
+}
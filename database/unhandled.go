@@ -0,0 +1,71 @@
+package database
+
+import (
+	"gorm.io/gorm/clause"
+)
+
+// a log of a watched contract without handler or mapping, stored once per log.
+// event is empty if the topic is not in the abi active at the block
+type UnhandledEvent struct {
+	Id          uint64 `gorm:"primaryKey" json:"id"`
+	Contract    string `gorm:"index:idx_unhandled_event" json:"contract"`
+	Event       string `gorm:"index:idx_unhandled_event" json:"event"`
+	Address     string `json:"address"`
+	Topic       string `json:"topic"`
+	Args        string `json:"args"` // decoded arguments as a json object
+	Data        string `json:"data"` // raw data if not decoded
+	BlockNumber uint64 `json:"blockNumber"`
+	TxHash      string `gorm:"uniqueIndex:idx_unhandled_log" json:"txHash"`
+	LogIndex    uint   `gorm:"uniqueIndex:idx_unhandled_log" json:"logIndex"`
+}
+
+// number of unhandled logs of an event
+type UnhandledCount struct {
+	Contract  string `json:"contract"`
+	Event     string `json:"event"`
+	Count     int64  `json:"count"`
+	LastBlock uint64 `json:"lastBlock"`
+}
+
+func InitUnhandledEvent() error {
+	return GlobalDataBase.AutoMigrate(&UnhandledEvent{})
+}
+
+// store an unhandled log, return false if it is already stored
+func (e *UnhandledEvent) CreateUnhandledEvent() (bool, error) {
+	result := GlobalDataBase.Clauses(clause.OnConflict{DoNothing: true}).Create(e)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// unhandled logs of an event by specify start and num, all events of the contract if event is empty
+func ListUnhandledEvents(contract, event string, start, num int) ([]UnhandledEvent, error) {
+	var events []UnhandledEvent
+	tx := GlobalDataBase.Model(&UnhandledEvent{}).Where("contract = ?", contract)
+	if event != "" {
+		tx = tx.Where("event = ?", event)
+	}
+	err := tx.Order("id").Limit(num).Offset(start).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// count unhandled logs by contract and event
+func CountUnhandledEvents() ([]UnhandledCount, error) {
+	var counts []UnhandledCount
+	err := GlobalDataBase.Model(&UnhandledEvent{}).
+		Select("contract, event, count(*) as count, max(block_number) as last_block").
+		Group("contract, event").Order("contract, event").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// result of probing a provider endpoint
+type ProbeRecord struct {
+	Id      uint64    `gorm:"primaryKey" json:"id"`
+	Address string    `gorm:"index" json:"address"`
+	Time    time.Time `gorm:"index" json:"time"`
+	Success bool      `json:"success"`
+	Latency int64     `json:"latency"` // ms
+	Error   string    `json:"error"`
+	Online  bool      `json:"online"` // provider status after this probe
+}
+
+func InitProbeRecord() error {
+	return GlobalDataBase.AutoMigrate(&ProbeRecord{})
+}
+
+// store probe record to db
+func (r *ProbeRecord) CreateProbeRecord() error {
+	return GlobalDataBase.Create(r).Error
+}
+
+// status of a provider after its last probe, false if it is not probed yet
+func ProviderOnline(address string) (bool, error) {
+	return ProviderOnlineTx(GlobalDataBase, address)
+}
+
+// ProviderOnline within a transaction of the caller
+func ProviderOnlineTx(db *gorm.DB, address string) (bool, error) {
+	var records []ProbeRecord
+	err := db.Model(&ProbeRecord{}).Where("address = ?", address).Order("time desc, id desc").Limit(1).Find(&records).Error
+	if err != nil {
+		return false, err
+	}
+	if len(records) == 0 {
+		return false, nil
+	}
+
+	return records[0].Online, nil
+}
+
+// list probe records of a provider in [from, to]
+func ListProbeRecords(address string, from, to time.Time) ([]ProbeRecord, error) {
+	var records []ProbeRecord
+	err := GlobalDataBase.Model(&ProbeRecord{}).
+		Where("address = ? AND time >= ? AND time <= ?", address, from, to).
+		Order("time").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// list all providers to probe
+func ListProviderEndpoints() ([]Provider, error) {
+	var providers []Provider
+	err := GlobalDataBase.Model(&Provider{}).Find(&providers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"math/big"
+	"time"
+
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// a signed withdrawal voucher issued to a provider
+type Voucher struct {
+	Id        uint64    `gorm:"primaryKey" json:"id"`
+	Provider  string    `gorm:"index" json:"provider"`
+	Amount    *big.Int  `gorm:"serializer:bigint" json:"amount"`
+	Nonce     uint64    `json:"nonce"`
+	ChainId   *big.Int  `gorm:"serializer:bigint" json:"chainId"`
+	Market    string    `json:"market"`
+	Signer    string    `json:"signer"`
+	Signature string    `json:"signature"` // hex with 0x prefix
+	Time      time.Time `gorm:"index" json:"time"`
+}
+
+func InitVoucher() error {
+	return GlobalDataBase.AutoMigrate(&Voucher{})
+}
+
+// amount a provider can withdraw: the balance credited by settlements
+// and reduced by indexed withdrawals and penalties, never below zero
+func GetWithdrawable(address string) (*big.Int, uint64, error) {
+	var ps ProfitStore
+	err := GlobalDataBase.Model(&ProfitStore{}).Where("address = ?", address).First(&ps).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ps.withdrawable(), ps.Nonce, nil
+}
+
+func (ps ProfitStore) withdrawable() *big.Int {
+	if ps.Balance == nil || ps.Balance.Sign() < 0 {
+		return big.NewInt(0)
+	}
+
+	return new(big.Int).Set(ps.Balance)
+}
+
+// store an issued voucher, it fails if the nonce of the provider changed since it was read
+func (v *Voucher) CreateVoucher() error {
+	return GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		var ps ProfitStore
+		err := tx.Model(&ProfitStore{}).Where("address = ?", v.Provider).First(&ps).Error
+		if err != nil {
+			return err
+		}
+		if ps.Nonce != v.Nonce {
+			return xerrors.Errorf("nonce of %s changed from %d to %d", v.Provider, v.Nonce, ps.Nonce)
+		}
+
+		return tx.Create(v).Error
+	})
+}
+
+// vouchers issued to a provider
+func ListVouchers(address string) ([]Voucher, error) {
+	var vouchers []Voucher
+	err := GlobalDataBase.Model(&Voucher{}).Where("provider = ?", address).Order("id").Find(&vouchers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return vouchers, nil
+}
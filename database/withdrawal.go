@@ -0,0 +1,156 @@
+package database
+
+import (
+	"math/big"
+
+	"gorm.io/gorm"
+)
+
+// kinds of balance changes
+const (
+	BalanceSettle   = "settle"
+	BalanceWithdraw = "withdraw"
+	BalanceSeed     = "seed"
+)
+
+// a withdrawal of a provider, indexed once per log
+type Withdrawal struct {
+	Id          uint64   `gorm:"primaryKey" json:"id"`
+	Provider    string   `gorm:"index" json:"provider"`
+	Amount      *big.Int `gorm:"serializer:bigint" json:"amount"`
+	Balance     *big.Int `gorm:"serializer:bigint" json:"balance"` // balance after the withdrawal
+	Nonce       uint64   `json:"nonce"`                            // nonce after the withdrawal
+	BlockNumber uint64   `json:"blockNumber"`
+	TxHash      string   `gorm:"uniqueIndex:idx_withdrawal_log" json:"txHash"`
+	LogIndex    uint     `gorm:"uniqueIndex:idx_withdrawal_log" json:"logIndex"`
+}
+
+// a change of provider balance
+type BalanceChange struct {
+	Id          uint64   `gorm:"primaryKey" json:"id"`
+	Provider    string   `gorm:"index" json:"provider"`
+	Kind        string   `json:"kind"`                             // settle, withdraw or seed
+	Delta       *big.Int `gorm:"serializer:bigint" json:"delta"`   // negative when taken
+	Balance     *big.Int `gorm:"serializer:bigint" json:"balance"` // balance after the change
+	BlockNumber uint64   `json:"blockNumber"`
+	TxHash      string   `json:"txHash"`
+}
+
+func InitWithdrawal() error {
+	return GlobalDataBase.AutoMigrate(&Withdrawal{}, &BalanceChange{})
+}
+
+// store a withdrawal, take it from the provider balance and increase the nonce.
+// return false if the log is already indexed
+func (w *Withdrawal) CreateWithdrawal() (bool, error) {
+	return w.CreateWithdrawalTx(GlobalDataBase)
+}
+
+// CreateWithdrawal within a transaction of the caller
+func (w *Withdrawal) CreateWithdrawalTx(db *gorm.DB) (bool, error) {
+	created := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var cnt int64
+		err := tx.Model(&Withdrawal{}).Where("tx_hash = ? AND log_index = ?", w.TxHash, w.LogIndex).Count(&cnt).Error
+		if err != nil {
+			return err
+		}
+		if cnt > 0 {
+			return nil
+		}
+
+		var ps ProfitStore
+		err = tx.Model(&ProfitStore{}).Where("address = ?", w.Provider).First(&ps).Error
+		if err != nil {
+			return err
+		}
+
+		w.Balance = new(big.Int).Sub(ps.Balance, w.Amount)
+		w.Nonce = ps.Nonce + 1
+
+		err = tx.Model(&ProfitStore{}).Where("address = ?", w.Provider).Updates(map[string]interface{}{
+			"balance": EncodeBigInt(w.Balance),
+			"nonce":   w.Nonce,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(w).Error
+		if err != nil {
+			return err
+		}
+
+		created = true
+		return addBalanceChange(tx, BalanceChange{
+			Provider:    w.Provider,
+			Kind:        BalanceWithdraw,
+			Delta:       new(big.Int).Neg(w.Amount),
+			Balance:     w.Balance,
+			BlockNumber: w.BlockNumber,
+			TxHash:      w.TxHash,
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return created, nil
+}
+
+// set the balance and nonce of a provider read from the contract at a block,
+// within a transaction of the caller
+func SeedBalanceTx(db *gorm.DB, address string, balance *big.Int, nonce uint64, blockNumber uint64) error {
+	var ps ProfitStore
+	err := db.Model(&ProfitStore{}).Where("address = ?", address).First(&ps).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(&ProfitStore{}).Where("address = ?", address).Updates(map[string]interface{}{
+		"balance": EncodeBigInt(balance),
+		"nonce":   nonce,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	delta := new(big.Int).Set(balance)
+	if ps.Balance != nil {
+		delta.Sub(delta, ps.Balance)
+	}
+	return addBalanceChange(db, BalanceChange{
+		Provider:    address,
+		Kind:        BalanceSeed,
+		Delta:       delta,
+		Balance:     balance,
+		BlockNumber: blockNumber,
+	})
+}
+
+func addBalanceChange(db *gorm.DB, c BalanceChange) error {
+	return db.Create(&c).Error
+}
+
+// withdrawals of a provider
+func ListWithdrawals(address string) ([]Withdrawal, error) {
+	var withdrawals []Withdrawal
+	err := GlobalDataBase.Model(&Withdrawal{}).Where("provider = ?", address).Order("id").Find(&withdrawals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return withdrawals, nil
+}
+
+// balance changes of a provider in indexing order
+func ListBalanceHistory(address string) ([]BalanceChange, error) {
+	var changes []BalanceChange
+	err := GlobalDataBase.Model(&BalanceChange{}).Where("provider = ?", address).Order("id").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
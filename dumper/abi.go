@@ -1,9 +1,47 @@
 package dumper
 
 import (
-	"github.com/grid/contracts/go/market"
-	"github.com/grid/contracts/go/registry"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/gridprotocol/dumper/contracts/market"
+	"github.com/gridprotocol/dumper/contracts/registry"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"golang.org/x/xerrors"
+)
+
+// default abis of the contracts from the bindings vendored from grid-contracts,
+// used from block 0 unless an abi file replaces them
+var (
+	RegisterABI = registry.RegistryABI
+	MarketABI   = market.MarketABI
 )
 
-var RegisterABI = registry.RegistryABI
-var MarketABI = market.MarketABI
+// parse the abi of a contract
+func parseContractABI(data string) (abi.ABI, error) {
+	return abi.JSON(strings.NewReader(data))
+}
+
+// read an abi json file, either a plain abi array or a build artifact with an abi field
+func LoadABIFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var artifact struct {
+		ABI json.RawMessage `json:"abi"`
+	}
+	if json.Unmarshal(data, &artifact) == nil && len(artifact.ABI) > 0 {
+		return string(artifact.ABI), nil
+	}
+
+	var plain []json.RawMessage
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return "", xerrors.Errorf("%s is not an abi: %w", path, err)
+	}
+
+	return string(data), nil
+}
@@ -1,13 +1,12 @@
 package dumper
 
 import (
-	"fmt"
 	"math/big"
 
 	"github.com/gridprotocol/dumper/database"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
 )
 
 type AddNodeEvent struct {
@@ -46,23 +45,27 @@ type AddNodeEvent struct {
 
 type DelNodeEvent struct {
 	Cp common.Address
-	ID uint64
+	Id uint64
 }
 
 // unpack log data and store into db
-func (d *Dumper) HandleAddNode(log types.Log) error {
+func (d *Dumper) HandleAddNode(tx *gorm.DB, ev ContractEvent) error {
 	var out AddNodeEvent
 
-	// abi0 = registry
-	err := d.unpack(log, d.contractABI[0], &out)
+	err := ev.Unpack(&out)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("out: ", out)
+	// the prober only updates nodes when the provider status changes,
+	// so a new node starts with the current status
+	online, err := database.ProviderOnlineTx(tx, out.Cp.Hex())
+	if err != nil {
+		return err
+	}
 
 	// make node with data
-	nodeInfo := database.Node{
+	nodeInfo := database.NodeStore{
 		Address: out.Cp.Hex(),
 		Id:      out.Id,
 
@@ -87,12 +90,12 @@ func (d *Dumper) HandleAddNode(log types.Log) error {
 		Sold:  out.Sold,
 		Avail: out.Avail,
 
-		Online: false,
+		Online: online,
 	}
 
 	logger.Info("============= store AddNode..", nodeInfo)
 	// store data
-	err = nodeInfo.CreateNode()
+	err = nodeInfo.CreateNodeTx(tx)
 	if err != nil {
 		logger.Debug("store AddNode error: ", err.Error())
 		return err
@@ -104,20 +107,17 @@ func (d *Dumper) HandleAddNode(log types.Log) error {
 	return nil
 }
 
-func (d *Dumper) HandleDelNode(log types.Log) error {
+func (d *Dumper) HandleDelNode(tx *gorm.DB, ev ContractEvent) error {
 	var out DelNodeEvent
 
-	// abi0 = registry
-	err := d.unpack(log, d.contractABI[0], &out)
+	err := ev.Unpack(&out)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("out: ", out)
-
 	logger.Info("============= Handle DelNode..", out)
-	// store data
-	err = database.SetExist(out.Cp.String(), out.ID, false)
+	// set node not exist and decrease node resource
+	err = database.DeleteNodeTx(tx, out.Cp.Hex(), out.Id)
 	if err != nil {
 		logger.Debug("Handle delNode error: ", err.Error())
 		return err
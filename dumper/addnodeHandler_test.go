@@ -0,0 +1,84 @@
+package dumper
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// a log of the test registry for testCp at a position
+func registryLog(t *testing.T, d *Dumper, name string, block uint64, index uint, args ...interface{}) types.Log {
+	ev := d.contracts[testRegistry].version(0).abi.Events[name]
+	data, err := ev.Inputs.NonIndexed().Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return types.Log{
+		Address:     testRegistry,
+		Topics:      []common.Hash{ev.ID, common.BytesToHash(testCp.Bytes())},
+		Data:        data,
+		BlockNumber: block,
+		TxHash:      common.BigToHash(new(big.Int).SetUint64(block*1000 + uint64(index))),
+		Index:       index,
+	}
+}
+
+// arguments of an AddNode log
+func addNodeArgs(id uint64) []interface{} {
+	var out AddNodeEvent
+	one := big.NewInt(1)
+	out.Cpu.CpuPriceMon, out.Cpu.CpuPriceSec, out.Cpu.Model, out.Cpu.Core = one, one, "cpu", 8
+	out.Gpu.GpuPriceMon, out.Gpu.GpuPriceSec, out.Gpu.Model = one, one, "gpu"
+	out.Mem.MemPriceMon, out.Mem.MemPriceSec, out.Mem.Num = one, one, 2
+	out.Disk.DiskPriceMon, out.Disk.DiskPriceSec, out.Disk.Num = one, one, 3
+
+	return []interface{}{id, out.Cpu, out.Gpu, out.Mem, out.Disk, true, false, true}
+}
+
+// an AddNode event of the test registry
+func addNodeLog(t *testing.T, d *Dumper, id uint64) ContractEvent {
+	e, ok := d.match(registryLog(t, d, "AddNode", 1, 0, addNodeArgs(id)...))
+	if !ok {
+		t.Fatal("AddNode log not matched")
+	}
+
+	return e
+}
+
+func TestAddNodeOnline(t *testing.T) {
+	d, _ := newTestChain(t)
+
+	// not probed yet
+	err := d.HandleAddNode(database.GlobalDataBase, addNodeLog(t, d, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := database.ProbeRecord{Address: testCp.Hex(), Time: time.Now(), Success: true, Online: true}
+	err = record.CreateProbeRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the prober does not touch nodes until the status changes again
+	err = d.HandleAddNode(database.GlobalDataBase, addNodeLog(t, d, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[uint64]bool{1: false, 2: true} {
+		n, err := database.GetNodeByCpAndId(testCp.Hex(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Online != want || n.CPUModel != "cpu" {
+			t.Fatalf("node %d online %v model %s, want online %v", id, n.Online, n.CPUModel, want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package dumper
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// BootstrapChain reads contract state and headers, an ethclient or the client of a simulated backend
+type BootstrapChain interface {
+	ethereum.ContractCaller
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// seed an empty database with the registry and market state at a block,
+// and continue indexing events from the next block.
+// orders whose node is not enumerated are skipped and returned as drifts
+func (d *Dumper) Bootstrap(ctx context.Context, chain BootstrapChain, blockNumber uint64) ([]Drift, error) {
+	existing, err := database.ListProviderEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, xerrors.New("bootstrap needs an empty database")
+	}
+
+	// read with the abis active at the block
+	registry, err := d.contractByName(RegistryContract)
+	if err != nil {
+		return nil, err
+	}
+	market, err := d.contractByName(MarketContract)
+	if err != nil {
+		return nil, err
+	}
+	registryVersion, marketVersion := registry.version(blockNumber), market.version(blockNumber)
+	if registryVersion == nil || marketVersion == nil {
+		return nil, xerrors.Errorf("no abi at block %d", blockNumber)
+	}
+	reader, err := newContractReader(chain, registry.address, market.address, registryVersion.abi, marketVersion.abi)
+	if err != nil {
+		return nil, err
+	}
+	block := new(big.Int).SetUint64(blockNumber)
+
+	// remuneration up to the block is in the balances read from the contract,
+	// orders are settled from the block time on
+	head, err := chain.HeaderByNumber(ctx, block)
+	if err != nil {
+		return nil, xerrors.Errorf("get header %d: %w", blockNumber, err)
+	}
+	blockTime := time.Unix(int64(head.Time), 0)
+
+	logger.Info("bootstrap from block: ", blockNumber)
+
+	// read the whole state first, then write it in one transaction,
+	// so a failed bootstrap leaves the database empty and can be retried
+	cps, err := reader.getProviders(ctx, block)
+	if err != nil {
+		return nil, xerrors.Errorf("get providers: %w", err)
+	}
+
+	providers := make([]database.Provider, 0, len(cps))
+	balances := make([]balanceView, 0, len(cps))
+	var nodes []database.NodeStore
+	// sold of nodes is set after orders are stored
+	sold := make(map[common.Address]map[uint64]bool)
+	for _, cp := range cps {
+		view, err := reader.getProvider(ctx, block, cp)
+		if err != nil {
+			return nil, xerrors.Errorf("get provider %s: %w", cp.Hex(), err)
+		}
+
+		providers = append(providers, database.Provider{
+			Address: cp.Hex(),
+			Name:    view.Name,
+			IP:      view.Ip,
+			Domain:  view.Domain,
+			Port:    view.Port,
+		})
+
+		bv, err := reader.getBalance(ctx, block, cp)
+		if err != nil {
+			return nil, xerrors.Errorf("get balance of %s: %w", cp.Hex(), err)
+		}
+		balances = append(balances, bv)
+
+		ids, err := reader.getNodeIds(ctx, block, cp)
+		if err != nil {
+			return nil, xerrors.Errorf("get node ids of %s: %w", cp.Hex(), err)
+		}
+
+		sold[cp] = make(map[uint64]bool)
+		for _, id := range ids {
+			nv, err := reader.getNode(ctx, block, cp, id)
+			if err != nil {
+				return nil, xerrors.Errorf("get node %s/%d: %w", cp.Hex(), id, err)
+			}
+
+			nodes = append(nodes, database.NodeStore{
+				Address: cp.Hex(),
+				Id:      id,
+
+				CPUPriceMon: nv.CpuPriceMon,
+				CPUPriceSec: nv.CpuPriceSec,
+				CPUModel:    nv.CpuModel,
+				CPUCore:     nv.CpuCore,
+
+				GPUPriceMon: nv.GpuPriceMon,
+				GPUPriceSec: nv.GpuPriceSec,
+				GPUModel:    nv.GpuModel,
+
+				MemPriceMon: nv.MemPriceMon,
+				MemPriceSec: nv.MemPriceSec,
+				MemCapacity: int64(nv.MemNum),
+
+				DiskPriceMon: nv.DiskPriceMon,
+				DiskPriceSec: nv.DiskPriceSec,
+				DiskCapacity: int64(nv.DiskNum),
+
+				Exist: nv.Exist,
+				Avail: nv.Avail,
+			})
+			sold[cp][id] = nv.Sold
+		}
+	}
+
+	ids, err := reader.getOrderIds(ctx, block)
+	if err != nil {
+		return nil, xerrors.Errorf("get order ids: %w", err)
+	}
+	orders := make([]database.Order, 0, len(ids))
+	var drifts []Drift
+	for _, id := range ids {
+		ov, err := reader.getOrder(ctx, block, id)
+		if err != nil {
+			return nil, xerrors.Errorf("get order %d: %w", id, err)
+		}
+
+		// an order can only be stored with its node
+		if _, ok := sold[ov.Cp][ov.Nid]; !ok {
+			logger.Warnw("bootstrap skips order without enumerated node", "order", id, "provider", ov.Cp.Hex(), "node", ov.Nid)
+			drifts = append(drifts, Drift{Kind: "order", Key: fmt.Sprint(id), Field: "exist", DB: "false", Chain: "true"})
+			continue
+		}
+
+		out := CreateOrderEvent{
+			Cp:     ov.Cp,
+			Id:     id,
+			Nid:    ov.Nid,
+			Act:    ov.Act,
+			Pro:    ov.Pro,
+			Dur:    ov.Dur,
+			Status: ov.Status,
+		}
+		order := out.order(ov.User)
+		order.LastSettle = blockTime
+		orders = append(orders, order)
+	}
+
+	err = database.GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		for _, provider := range providers {
+			_, err := provider.UpsertProviderTx(tx, blockNumber, "")
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, node := range nodes {
+			err := node.CreateNodeTx(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, order := range orders {
+			err := storeOrder(tx, order)
+			if err != nil {
+				return err
+			}
+		}
+
+		for i, provider := range providers {
+			err := database.SeedBalanceTx(tx, provider.Address, balances[i].Balance, balances[i].Nonce, blockNumber)
+			if err != nil {
+				return err
+			}
+		}
+
+		for cp, nodes := range sold {
+			for id, s := range nodes {
+				err := database.SetSoldTx(tx, cp.Hex(), id, s)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// counters are updated per entity above, recompute them from the seeded state
+		err := database.RebuildProviderCapacityTx(tx)
+		if err != nil {
+			return err
+		}
+		_, err = database.ReconcileGlobalTx(tx, true)
+		if err != nil {
+			return err
+		}
+
+		// continue from the next block
+		return database.SetBlockNumberTx(tx, int64(blockNumber+1))
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.fromBlock = new(big.Int).SetUint64(blockNumber + 1)
+
+	logger.Info("bootstrap done, providers: ", len(cps), " orders: ", len(orders), " skipped: ", len(drifts))
+
+	return drifts, nil
+}
@@ -0,0 +1,74 @@
+package dumper
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+)
+
+func TestBootstrap(t *testing.T) {
+	d, chain := newTestChain(t)
+
+	// an order on a node the registry does not enumerate
+	o := chain.orders[1]
+	o.Nid = 9
+	chain.orders[2] = o
+
+	drifts, err := d.Bootstrap(context.Background(), chain, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 1 || drifts[0] != (Drift{Kind: "order", Key: "2", Field: "exist", DB: "false", Chain: "true"}) {
+		t.Fatalf("unexpected drifts: %+v", drifts)
+	}
+	_, err = database.GetOrderById(2)
+	if err == nil {
+		t.Fatal("order without node is stored")
+	}
+
+	// balance and nonce are read from the contract
+	profit, err := database.GetProfitByAddress(testCp.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profit.Balance.Cmp(big.NewInt(500)) != 0 || profit.Nonce != 2 {
+		t.Fatalf("balance %s nonce %d, want 500 and 2", profit.Balance, profit.Nonce)
+	}
+
+	// the order is settled from the block time, earlier remuneration is in the balance
+	order, err := database.GetOrderById(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !order.LastSettle.Equal(time.Unix(600, 0)) {
+		t.Fatalf("last settle %v, want the block time", order.LastSettle)
+	}
+
+	s, ok, err := database.SettleOrder(order, time.Unix(700, 0), 11)
+	if err != nil || !ok {
+		t.Fatal("settle order: ", ok, err)
+	}
+	if !s.From.Equal(time.Unix(600, 0)) {
+		t.Fatalf("settled from %v", s.From)
+	}
+	want := new(big.Int).Mul(order.Price, big.NewInt(100))
+	if s.Amount.Cmp(want) != 0 {
+		t.Fatalf("settled %s, want %s", s.Amount, want)
+	}
+
+	profit, err = database.GetProfitByAddress(testCp.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profit.Balance.Cmp(new(big.Int).Add(big.NewInt(500), want)) != 0 {
+		t.Fatalf("balance %s after settlement", profit.Balance)
+	}
+
+	cursor, err := database.GetBlockNumber()
+	if err != nil || cursor != 11 {
+		t.Fatal("cursor: ", cursor, err)
+	}
+}
@@ -0,0 +1,182 @@
+package dumper
+
+import (
+	"sort"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"golang.org/x/xerrors"
+)
+
+// names of watched contracts
+const (
+	RegistryContract = "registry"
+	MarketContract   = "market"
+)
+
+// a watched contract with its abi versions
+type contract struct {
+	name    string
+	address common.Address
+
+	// sorted by from block
+	versions []*contractVersion
+}
+
+// an abi of a contract active from a block, with its own event table
+type contractVersion struct {
+	fromBlock uint64
+	abi       abi.ABI
+
+	// by topic0
+	events  map[common.Hash]abi.Event
+	indexed map[common.Hash]abi.Arguments
+}
+
+func newContractVersion(fromBlock uint64, ABI abi.ABI) *contractVersion {
+	v := &contractVersion{
+		fromBlock: fromBlock,
+		abi:       ABI,
+		events:    make(map[common.Hash]abi.Event),
+		indexed:   make(map[common.Hash]abi.Arguments),
+	}
+
+	for _, event := range ABI.Events {
+		v.events[event.ID] = event
+
+		var indexed abi.Arguments
+		for _, arg := range event.Inputs {
+			if arg.Indexed {
+				indexed = append(indexed, arg)
+			}
+		}
+		v.indexed[event.ID] = indexed
+	}
+
+	return v
+}
+
+func newContract(name string, address common.Address) *contract {
+	return &contract{
+		name:    name,
+		address: address,
+	}
+}
+
+// add an abi active from a block, an abi of the same block is replaced
+func (c *contract) addVersion(fromBlock uint64, ABI abi.ABI) {
+	v := newContractVersion(fromBlock, ABI)
+
+	i := sort.Search(len(c.versions), func(i int) bool { return c.versions[i].fromBlock >= fromBlock })
+	if i < len(c.versions) && c.versions[i].fromBlock == fromBlock {
+		c.versions[i] = v
+		return
+	}
+
+	c.versions = append(c.versions, nil)
+	copy(c.versions[i+1:], c.versions[i:])
+	c.versions[i] = v
+}
+
+// abi version active at a block, nil if none
+func (c *contract) version(blockNumber uint64) *contractVersion {
+	i := sort.Search(len(c.versions), func(i int) bool { return c.versions[i].fromBlock > blockNumber })
+	if i == 0 {
+		return nil
+	}
+
+	return c.versions[i-1]
+}
+
+// ContractEvent is a log matched to an event of a watched contract
+type ContractEvent struct {
+	Contract string // name of the emitting contract
+	Name     string // event name
+	Log      types.Log
+
+	v *contractVersion
+}
+
+// key of an event handler
+type eventKey struct {
+	contract string
+	name     string
+}
+
+func (e ContractEvent) key() eventKey {
+	return eventKey{e.Contract, e.Name}
+}
+
+// unpack data and indexed topics of the event into out
+func (e ContractEvent) Unpack(out interface{}) error {
+	err := e.v.abi.UnpackIntoInterface(out, e.Name, e.Log.Data)
+	if err != nil {
+		return err
+	}
+
+	return abi.ParseTopics(out, e.v.indexed[e.Log.Topics[0]], e.Log.Topics[1:])
+}
+
+// match a log by emitting address and topic0, with the abi version active at its block
+func (d *Dumper) match(log types.Log) (ContractEvent, bool) {
+	if len(log.Topics) == 0 {
+		return ContractEvent{}, false
+	}
+
+	c, ok := d.contracts[log.Address]
+	if !ok {
+		return ContractEvent{}, false
+	}
+
+	v := c.version(log.BlockNumber)
+	if v == nil {
+		return ContractEvent{}, false
+	}
+
+	event, ok := v.events[log.Topics[0]]
+	if !ok {
+		return ContractEvent{}, false
+	}
+
+	return ContractEvent{
+		Contract: c.name,
+		Name:     event.Name,
+		Log:      log,
+		v:        v,
+	}, true
+}
+
+// watched contract by name
+func (d *Dumper) contractByName(name string) (*contract, error) {
+	for _, c := range d.contracts {
+		if c.name == name {
+			return c, nil
+		}
+	}
+
+	return nil, xerrors.Errorf("contract %s is not watched", name)
+}
+
+// an abi file of a contract active from a block
+type abiFile struct {
+	contract  string
+	fromBlock uint64
+	path      string
+}
+
+// parse an abi of a watched contract and activate it from a block
+func (d *Dumper) addABIVersion(name string, fromBlock uint64, data string) error {
+	c, err := d.contractByName(name)
+	if err != nil {
+		return err
+	}
+
+	ABI, err := parseContractABI(data)
+	if err != nil {
+		return err
+	}
+	c.addVersion(fromBlock, ABI)
+
+	return nil
+}
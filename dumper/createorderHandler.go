@@ -1,14 +1,13 @@
 package dumper
 
 import (
-	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/gridprotocol/dumper/database"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
 )
 
 type CreateOrderEvent struct {
@@ -21,19 +20,12 @@ type CreateOrderEvent struct {
 	Status uint8
 }
 
-func (d *Dumper) HandleCreateOrder(log types.Log, from common.Address) error {
-	var out CreateOrderEvent
-
-	// abi1 = market
-	err := d.unpack(log, d.contractABI[1], &out)
-	if err != nil {
-		return err
-	}
-
+// make an order of the event created by user
+func (out CreateOrderEvent) order(user common.Address) database.Order {
 	startTime := new(big.Int).Add(out.Act, out.Pro)
 	endTime := new(big.Int).Add(startTime, out.Dur)
-	orderInfo := database.Order{
-		User:         from.Hex(),
+	return database.Order{
+		User:         user.Hex(),
 		Provider:     out.Cp.Hex(),
 		Id:           out.Id,
 		Nid:          out.Nid,
@@ -44,37 +36,42 @@ func (d *Dumper) HandleCreateOrder(log types.Log, from common.Address) error {
 		Duration:     out.Dur.Int64(),
 		Status:       out.Status,
 	}
+}
 
-	fmt.Println("===================== order info:", orderInfo)
+func (d *Dumper) HandleCreateOrder(tx *gorm.DB, ev ContractEvent, from common.Address) error {
+	var out CreateOrderEvent
 
-	logger.Info("store order..")
-	err = orderInfo.CreateOrder()
+	err := ev.Unpack(&out)
 	if err != nil {
-		logger.Debug("store create order error: ", err.Error())
 		return err
 	}
 
-	// set node sold=true
-	database.SetSold(orderInfo.Provider, orderInfo.Nid, true)
+	orderInfo := out.order(from)
+
+	logger.Debug("order info: ", orderInfo)
 
-	// get node info from db
-	nodeInfo, err := database.GetNodeByCpAndId(orderInfo.Provider, orderInfo.Id)
+	return storeOrder(tx, orderInfo)
+}
+
+// store an order and add its value to the provider profit, within a transaction of the caller
+func storeOrder(tx *gorm.DB, orderInfo database.Order) error {
+	// store order, node sold and used resource are updated together
+	logger.Info("store order..")
+	err := orderInfo.CreateOrderTx(tx)
 	if err != nil {
+		logger.Debug("store create order error: ", err.Error())
 		return err
 	}
 
 	// get profit info
-	profitInfo, err := database.GetProfitByAddress(orderInfo.Provider)
+	profitInfo, err := database.GetProfitByAddressTx(tx, orderInfo.Provider)
 	if err != nil {
 		return err
 	}
 
-	// init profit info
-	// (cpuPrice + gpuPrice + memPrice + diskPrice) * duration
-	price := new(big.Int).Add(nodeInfo.CPUPriceSec, nodeInfo.GPUPriceSec)
-	price.Add(price, nodeInfo.MemPriceSec)
-	price.Add(price, nodeInfo.DiskPriceSec)
-	price.Mul(price, big.NewInt(orderInfo.Duration))
+	// order value is settled with the same node price
+	// (cpuPrice + gpuPrice + memPrice*mem + diskPrice*disk) * duration
+	price := new(big.Int).Mul(orderInfo.Price, big.NewInt(orderInfo.Duration))
 
 	// update profit
 	profitInfo.Profit.Add(profitInfo.Profit, price)
@@ -83,7 +80,7 @@ func (d *Dumper) HandleCreateOrder(log types.Log, from common.Address) error {
 	}
 
 	// store new value
-	return profitInfo.UpdateProfit()
+	return profitInfo.UpdateProfitTx(tx)
 }
 
 type WithdrawEvent struct {
@@ -91,19 +88,31 @@ type WithdrawEvent struct {
 	Amount *big.Int
 }
 
-func (d *Dumper) HandleWithdraw(log types.Log) error {
+// parse a withdraw log, the balance and nonce of the provider are updated with it
+func (d *Dumper) HandleWithdraw(tx *gorm.DB, ev ContractEvent) error {
 	var out WithdrawEvent
-	err := d.unpack(log, d.contractABI[1], &out)
+	err := ev.Unpack(&out)
 	if err != nil {
 		return err
 	}
 
-	profit, err := database.GetProfitByAddress(out.Cp.Hex())
+	withdrawal := database.Withdrawal{
+		Provider:    out.Cp.Hex(),
+		Amount:      out.Amount,
+		BlockNumber: ev.Log.BlockNumber,
+		TxHash:      ev.Log.TxHash.Hex(),
+		LogIndex:    ev.Log.Index,
+	}
+
+	logger.Info("store withdraw..")
+	created, err := withdrawal.CreateWithdrawalTx(tx)
 	if err != nil {
+		logger.Debug("store withdraw error: ", err.Error())
 		return err
 	}
+	if !created {
+		logger.Debug("withdraw already indexed: ", withdrawal.TxHash, " ", withdrawal.LogIndex)
+	}
 
-	profit.Balance.Sub(profit.Balance, out.Amount)
-	profit.Nonce++
-	return profit.UpdateProfit()
+	return nil
 }
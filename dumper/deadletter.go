@@ -0,0 +1,148 @@
+package dumper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// DeadLetterPolicy decides what happens to the cursor when a handler fails
+type DeadLetterPolicy uint8
+
+const (
+	// store the failed event and retry it with backoff, the cursor moves on
+	DeadLetterRetry DeadLetterPolicy = iota
+	// store the failed event and stop the cursor at it until a retry with backoff succeeds or it is discarded
+	DeadLetterHalt
+)
+
+// record a failed event, return true if the cursor should stop at it.
+// the cursor also stops if the failure can not be recorded
+func (d *Dumper) deadLetter(ev ContractEvent, herr error) (bool, error) {
+	logger.Warnw("event handler failed", "contract", ev.Contract, "event", ev.Name, "block", ev.Log.BlockNumber, "tx", ev.Log.TxHash.Hex(), "error", herr.Error())
+
+	b, err := json.Marshal(ev.Log)
+	if err != nil {
+		return true, err
+	}
+
+	dl := database.DeadLetter{
+		Contract:    ev.Contract,
+		Event:       ev.Name,
+		Log:         string(b),
+		BlockNumber: ev.Log.BlockNumber,
+		TxHash:      ev.Log.TxHash.Hex(),
+		LogIndex:    ev.Log.Index,
+		Error:       herr.Error(),
+		LastFailed:  time.Now(),
+	}
+	err = dl.AddDeadLetter(d.deadLetterBackoff)
+	if err != nil {
+		return true, err
+	}
+	if dl.Status == database.DeadLetterDiscarded {
+		return false, nil
+	}
+	if dl.Attempts >= d.deadLetterMaxAttempts && d.deadLetterPolicy == DeadLetterRetry {
+		logger.Errorw("dead letter out of retries, retry or discard it manually", "id", dl.Id, "attempts", dl.Attempts)
+	}
+
+	return d.deadLetterPolicy == DeadLetterHalt, nil
+}
+
+// state of a log under the halt policy: settled if its dead letter was retried or discarded since,
+// it is not applied again; wait while its backoff runs or it is out of attempts, only a manual retry or discard moves on
+func (d *Dumper) haltedState(log types.Log) (bool, bool) {
+	if d.deadLetterPolicy != DeadLetterHalt {
+		return false, false
+	}
+
+	dl, err := database.GetDeadLetterByLog(log.TxHash.Hex(), log.Index)
+	if err != nil {
+		return false, false
+	}
+	if dl.Status != database.DeadLetterPending {
+		return true, false
+	}
+
+	return false, dl.Attempts >= d.deadLetterMaxAttempts || time.Now().Before(dl.NextRetry)
+}
+
+// retry pending events due now, in log order
+func (d *Dumper) retryDeadLetters(ctx context.Context, client txReader) {
+	dls, err := database.ListDueDeadLetters(time.Now(), d.deadLetterMaxAttempts)
+	if err != nil {
+		logger.Debug("list dead letters error: ", err.Error())
+		return
+	}
+
+	for _, dl := range dls {
+		err = d.retry(ctx, client, dl)
+		if err != nil {
+			logger.Debug("retry dead letter ", dl.Id, " error: ", err.Error())
+		}
+	}
+}
+
+// apply a dead letter again, it is resolved on success or counts another attempt
+func (d *Dumper) retry(ctx context.Context, client txReader, dl database.DeadLetter) error {
+	var log types.Log
+	err := json.Unmarshal([]byte(dl.Log), &log)
+	if err != nil {
+		return err
+	}
+
+	ev, ok := d.match(log)
+	if !ok {
+		return xerrors.Errorf("dead letter %d matches no event", dl.Id)
+	}
+
+	_, err = d.handle(ctx, client, ev)
+	if err != nil {
+		_, derr := d.deadLetter(ev, err)
+		if derr != nil {
+			return derr
+		}
+		return err
+	}
+
+	logger.Info("dead letter resolved: ", dl.Id)
+	return database.SetDeadLetterStatus(dl.Id, database.DeadLetterResolved)
+}
+
+// retry a dead letter now regardless of its backoff and attempts
+func (d *Dumper) RetryDeadLetter(ctx context.Context, id uint64) error {
+	dl, err := database.GetDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	if dl.Status == database.DeadLetterResolved {
+		return xerrors.Errorf("dead letter %d is resolved", id)
+	}
+
+	client, err := ethclient.DialContext(ctx, d.endpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return d.retry(ctx, client, dl)
+}
+
+// give up a dead letter, a halted cursor moves past it
+func (d *Dumper) DiscardDeadLetter(id uint64) error {
+	err := database.SetDeadLetterStatus(id, database.DeadLetterDiscarded)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return xerrors.Errorf("no dead letter %d", id)
+	}
+
+	return err
+}
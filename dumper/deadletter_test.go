@@ -0,0 +1,227 @@
+package dumper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// a dumper of the test contracts on the current database, as after a restart
+func testDumper(t *testing.T, opts ...Option) *Dumper {
+	opts = append([]Option{
+		WithABIFile(RegistryContract, 0, "testdata/registry.json"),
+		WithABIFile(MarketContract, 0, "testdata/market.json"),
+	}, opts...)
+
+	d, err := NewGRIDDumper("", testRegistry, testMarket, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// node 1 at 5/0, a delete of the missing node 9 failing at 6/0 and node 2 at 7/0
+func testLogs(t *testing.T, d *Dumper) []types.Log {
+	return []types.Log{
+		registryLog(t, d, "AddNode", 5, 0, addNodeArgs(1)...),
+		registryLog(t, d, "DelNode", 6, 0, uint64(9)),
+		registryLog(t, d, "AddNode", 7, 0, addNodeArgs(2)...),
+	}
+}
+
+func nodeExists(t *testing.T, id uint64) bool {
+	n, err := database.GetNodeByCpAndId(testCp.Hex(), id)
+	return err == nil && n.Exist
+}
+
+func testDeadLetter(t *testing.T) database.DeadLetter {
+	dls, err := database.ListDeadLetters(database.DeadLetterPending, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 {
+		t.Fatalf("%d pending dead letters", len(dls))
+	}
+
+	return dls[0]
+}
+
+func TestApplyLogsRestart(t *testing.T) {
+	err := database.InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := testDumper(t)
+	logs := []types.Log{
+		registryLog(t, d, "AddNode", 5, 0, addNodeArgs(1)...),
+		registryLog(t, d, "AddNode", 5, 1, addNodeArgs(2)...),
+		registryLog(t, d, "AddNode", 6, 0, addNodeArgs(3)...),
+	}
+
+	// stopped after the first log, the cursor block is still 5
+	if !d.applyLogs(context.Background(), nil, logs[:1], nil) {
+		t.Fatal("logs not applied")
+	}
+
+	// fetched again from block 5 after a restart
+	d = testDumper(t)
+	if !d.applyLogs(context.Background(), nil, logs, nil) {
+		t.Fatal("logs not applied")
+	}
+	for id := uint64(1); id <= 3; id++ {
+		if !nodeExists(t, id) {
+			t.Fatalf("node %d not added", id)
+		}
+	}
+	if d.fromBlock.Uint64() != 7 {
+		t.Fatalf("from block %d", d.fromBlock)
+	}
+
+	// nothing is applied twice
+	d = testDumper(t)
+	if !d.applyLogs(context.Background(), nil, logs, nil) {
+		t.Fatal("logs not applied")
+	}
+	dls, err := database.ListDeadLetters(database.DeadLetterPending, 0, 10)
+	if err != nil || len(dls) != 0 {
+		t.Fatal("replayed logs failed: ", dls, err)
+	}
+	block, index, ok, err := database.GetLogCursor()
+	if err != nil || !ok || block != 6 || index != 0 {
+		t.Fatal("log cursor: ", block, index, ok, err)
+	}
+}
+
+func TestDeadLetterRetryPolicy(t *testing.T) {
+	err := database.InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := testDumper(t, WithDeadLetter(DeadLetterRetry, 3, 0))
+	logs := testLogs(t, d)
+
+	// the cursor moves past the failed log
+	if !d.applyLogs(context.Background(), nil, logs, nil) {
+		t.Fatal("logs not applied")
+	}
+	if !nodeExists(t, 2) || d.fromBlock.Uint64() != 8 {
+		t.Fatal("cursor stopped at the failed log: ", d.fromBlock)
+	}
+	dl := testDeadLetter(t)
+	if dl.BlockNumber != 6 || dl.Attempts != 1 {
+		t.Fatalf("unexpected dead letter %+v", dl)
+	}
+
+	// retried until out of attempts
+	d.retryDeadLetters(context.Background(), nil)
+	d.retryDeadLetters(context.Background(), nil)
+	d.retryDeadLetters(context.Background(), nil)
+	if dl = testDeadLetter(t); dl.Attempts != 3 {
+		t.Fatalf("%d attempts, want 3", dl.Attempts)
+	}
+
+	// a manual retry succeeds once the node exists
+	n := database.NodeStore{Address: testCp.Hex(), Id: 9, Exist: true}
+	err = n.CreateNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.retry(context.Background(), nil, dl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeExists(t, 9) {
+		t.Fatal("retried delete not applied")
+	}
+	dl, err = database.GetDeadLetter(dl.Id)
+	if err != nil || dl.Status != database.DeadLetterResolved {
+		t.Fatal("dead letter not resolved: ", dl.Status, err)
+	}
+}
+
+func TestDeadLetterHaltPolicy(t *testing.T) {
+	err := database.InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := testDumper(t, WithDeadLetter(DeadLetterHalt, 2, 0))
+	logs := testLogs(t, d)
+
+	// halted at the failed log, with no backoff it is retried on each round up to max attempts
+	for i, attempts := range []uint64{1, 2, 2} {
+		if d.applyLogs(context.Background(), nil, logs, nil) {
+			t.Fatal("cursor passed the failed log")
+		}
+		if d.fromBlock.Uint64() != 6 || nodeExists(t, 2) {
+			t.Fatalf("round %d: cursor not halted at block 6: %d", i, d.fromBlock)
+		}
+		if dl := testDeadLetter(t); dl.Attempts != attempts {
+			t.Fatalf("round %d: %d attempts, want %d", i, dl.Attempts, attempts)
+		}
+	}
+	if !nodeExists(t, 1) {
+		t.Fatal("log before the halt not applied")
+	}
+
+	// the halt is kept after a restart, logs before it are not applied again
+	d = testDumper(t, WithDeadLetter(DeadLetterHalt, 2, 0))
+	d.fromBlock.SetUint64(5)
+	if d.applyLogs(context.Background(), nil, logs, nil) || d.fromBlock.Uint64() != 6 {
+		t.Fatal("cursor not halted after restart: ", d.fromBlock)
+	}
+
+	// the cursor moves on once discarded
+	err = d.DiscardDeadLetter(testDeadLetter(t).Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.applyLogs(context.Background(), nil, logs, nil) {
+		t.Fatal("logs not applied after discard")
+	}
+	if !nodeExists(t, 2) || d.fromBlock.Uint64() != 8 {
+		t.Fatal("cursor not moved past the discarded log: ", d.fromBlock)
+	}
+}
+
+func TestDeadLetterHaltBackoff(t *testing.T) {
+	err := database.InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := testDumper(t, WithDeadLetter(DeadLetterHalt, 10, time.Hour))
+	logs := testLogs(t, d)
+
+	// the halted log is not retried before its backoff
+	for i := 0; i < 3; i++ {
+		if d.applyLogs(context.Background(), nil, logs, nil) || d.fromBlock.Uint64() != 6 {
+			t.Fatal("cursor not halted: ", d.fromBlock)
+		}
+	}
+	dl := testDeadLetter(t)
+	if dl.Attempts != 1 || !dl.NextRetry.After(time.Now()) {
+		t.Fatalf("retried within backoff: %+v", dl)
+	}
+
+	// a successful manual retry moves the cursor on
+	n := database.NodeStore{Address: testCp.Hex(), Id: 9, Exist: true}
+	err = n.CreateNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.retry(context.Background(), nil, dl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.applyLogs(context.Background(), nil, logs, nil) || !nodeExists(t, 2) || nodeExists(t, 9) {
+		t.Fatal("cursor not moved past the retried log: ", d.fromBlock)
+	}
+}
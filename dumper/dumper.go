@@ -2,18 +2,21 @@ package dumper
 
 import (
 	"context"
+	"errors"
 	"math/big"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/gridprotocol/dumper/database"
 	"github.com/gridprotocol/dumper/logs"
 
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
 )
 
 var (
@@ -22,58 +25,130 @@ var (
 )
 
 type Dumper struct {
-	endpoint        string
-	contractABI     []abi.ABI
+	endpoint string
+	// watched contracts by address, logs are routed by emitting address
+	contracts       map[common.Address]*contract
 	contractAddress []common.Address
 	// store           MapStore
 
 	fromBlock *big.Int
 
-	eventNameMap map[common.Hash]string
-	indexedMap   map[common.Hash]abi.Arguments
+	// latest indexed block header for scheduler
+	headCh chan *types.Header
+	// feed of order expired events
+	expiredFeed event.Feed
+
+	// utilization snapshot schedule
+	snapshotBlocks     uint64
+	snapshotInterval   time.Duration
+	snapshotRetention  time.Duration
+	snapshotResolution database.Resolution
+
+	// period of order settlement in chain time
+	settleInterval time.Duration
+
+	// period and weights of provider reputation in chain time
+	reputationInterval time.Duration
+	reputationWeights  database.ReputationWeights
+
+	// build a balance merkle root every n indexed blocks
+	balanceRootBlocks uint64
+
+	// seed an empty database from contract state at a block
+	bootstrap      bool
+	bootstrapBlock uint64
+
+	// check logs against receipts roots before applying them
+	verifyReceipts bool
+
+	// endpoints cross checked with the primary one, halted on disagreement
+	quorumEndpoints []string
+	quorumFeed      event.Feed
+	halted          bool
+	// depth below the chain head that is indexed and cross checked
+	quorumConfirmations uint64
+
+	// abi versions loaded at start
+	abiFiles []abiFile
+
+	// manifest files and their event mappings
+	manifests []string
+	mappings  map[eventKey][]mapping
+
+	// policy of events whose handler failed
+	deadLetterPolicy      DeadLetterPolicy
+	deadLetterMaxAttempts uint64
+	deadLetterBackoff     time.Duration
+	// last applied log, logs up to it are skipped when their block is fetched again
+	applied logCursor
+	// serializes handlers of indexing and manual retries
+	handleLk sync.Mutex
+
+	// logs without handler stored since start
+	unhandledLk sync.Mutex
+	unhandled   map[eventKey]database.UnhandledCount
 }
 
 // init a dumper with chain selected: local/dev
-func NewGRIDDumper(chain_ep string, registerAddress, marketAddress common.Address) (dumper *Dumper, err error) {
+func NewGRIDDumper(chain_ep string, registerAddress, marketAddress common.Address, opts ...Option) (dumper *Dumper, err error) {
 	dumper = &Dumper{
 		// store:        store,
-		endpoint:     chain_ep,
-		eventNameMap: make(map[common.Hash]string),
-		indexedMap:   make(map[common.Hash]abi.Arguments),
+		endpoint:  chain_ep,
+		contracts: make(map[common.Address]*contract),
+		mappings:  make(map[eventKey][]mapping),
+		unhandled: make(map[eventKey]database.UnhandledCount),
+		headCh:    make(chan *types.Header, 1),
+
+		snapshotInterval:   time.Hour,
+		settleInterval:     time.Hour,
+		reputationInterval: time.Hour,
+		reputationWeights:  database.DefaultReputationWeights(),
+
+		quorumConfirmations: 6,
+
+		deadLetterMaxAttempts: 10,
+		deadLetterBackoff:     time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(dumper)
 	}
 
 	// set contract
 	dumper.contractAddress = []common.Address{registerAddress, marketAddress}
 
-	// set abi
-	registerABI, err := abi.JSON(strings.NewReader(RegisterABI))
+	// default abis are active from block 0, abi files add versions activated at their blocks
+	for name, address := range map[string]common.Address{RegistryContract: registerAddress, MarketContract: marketAddress} {
+		dumper.contracts[address] = newContract(name, address)
+	}
+	err = dumper.addABIVersion(RegistryContract, 0, RegisterABI)
 	if err != nil {
 		return dumper, err
 	}
-
-	marketABI, err := abi.JSON(strings.NewReader(MarketABI))
+	err = dumper.addABIVersion(MarketContract, 0, MarketABI)
 	if err != nil {
 		return dumper, err
 	}
+	for _, f := range dumper.abiFiles {
+		data, err := LoadABIFile(f.path)
+		if err != nil {
+			return dumper, err
+		}
+		err = dumper.addABIVersion(f.contract, f.fromBlock, data)
+		if err != nil {
+			return dumper, xerrors.Errorf("abi %s: %w", f.path, err)
+		}
+	}
 
-	// set contract abi
-	dumper.contractABI = []abi.ABI{registerABI, marketABI}
-
-	// parse and save topics for each events
-	for _, ABI := range dumper.contractABI {
-		// each event
-		for name, event := range ABI.Events {
-			// save event in dumper
-			dumper.eventNameMap[event.ID] = name
-			var indexed abi.Arguments
-			// each topic
-			for _, arg := range ABI.Events[name].Inputs {
-				if arg.Indexed {
-					indexed = append(indexed, arg)
-				}
-			}
-			// save topics for each event in dumper
-			dumper.indexedMap[event.ID] = indexed
+	// manifests may watch more contracts
+	for _, path := range dumper.manifests {
+		m, err := LoadManifest(path)
+		if err != nil {
+			return dumper, err
+		}
+		err = dumper.addManifest(m)
+		if err != nil {
+			return dumper, xerrors.Errorf("manifest %s: %w", path, err)
 		}
 	}
 
@@ -88,13 +163,26 @@ func NewGRIDDumper(chain_ep string, registerAddress, marketAddress common.Addres
 	// set block number for dumper
 	dumper.fromBlock = big.NewInt(blockNumber)
 
+	// logs of the cursor block may be applied already after a halt or restart
+	block, index, ok, err := database.GetLogCursor()
+	if err != nil {
+		return dumper, err
+	}
+	dumper.applied = logCursor{set: ok, block: block, index: index}
+
 	return dumper, nil
 }
 
 // sync db with block chain every 10 sec
 func (d *Dumper) SubscribeGRID(ctx context.Context) {
+	// run block tasks in background
+	go d.scheduleBlocks(ctx)
+
 	for {
-		d.DumpGRID()
+		err := d.DumpGRID()
+		if errors.Is(err, ErrQuorumHalted) {
+			return
+		}
 
 		select {
 		case <-ctx.Done():
@@ -106,6 +194,10 @@ func (d *Dumper) SubscribeGRID(ctx context.Context) {
 
 // dump all events of blocks into db
 func (d *Dumper) DumpGRID() error {
+	if d.halted {
+		return ErrQuorumHalted
+	}
+
 	// dial chain
 	logger.Info("connect chain")
 	client, err := ethclient.DialContext(context.TODO(), d.endpoint)
@@ -123,6 +215,35 @@ func (d *Dumper) DumpGRID() error {
 	}
 	logger.Info("get current block number from chain: ", chainBlock)
 
+	// with quorum endpoints only blocks deep enough to be agreed on are indexed,
+	// a reorg at the head or a lagging endpoint is no disagreement
+	if len(d.quorumEndpoints) > 0 {
+		if chainBlock < d.quorumConfirmations {
+			logger.Info("no confirmed chain block, waiting..")
+			return nil
+		}
+		chainBlock -= d.quorumConfirmations
+	}
+
+	// seed from contract state instead of replaying from block 0
+	if d.bootstrap && d.fromBlock.Sign() == 0 {
+		n := d.bootstrapBlock
+		if n == 0 || n > chainBlock {
+			n = chainBlock
+		}
+		// skipped orders are logged by bootstrap
+		_, err = d.Bootstrap(context.TODO(), client, n)
+		if err != nil {
+			logger.Debug("bootstrap error: ", err)
+			return err
+		}
+	}
+
+	// failed events due for retry are applied before new ones
+	if d.deadLetterPolicy == DeadLetterRetry {
+		d.retryDeadLetters(context.TODO(), client)
+	}
+
 	// if no new chain block, return
 	if d.fromBlock.Cmp(new(big.Int).SetUint64(chainBlock)) > 0 {
 		logger.Info("no new chain block, waiting..")
@@ -132,112 +253,227 @@ func (d *Dumper) DumpGRID() error {
 	logger.Debug("dump from block: ", d.fromBlock)
 
 	// filter event logs from block
-	events, err := client.FilterLogs(context.TODO(), ethereum.FilterQuery{
+	// up to the chain block, quorum endpoints are queried with the same range
+	query := ethereum.FilterQuery{
 		FromBlock: d.fromBlock,
+		ToBlock:   new(big.Int).SetUint64(chainBlock),
 		Addresses: d.contractAddress,
-	})
+	}
+	events, err := client.FilterLogs(context.TODO(), query)
 	if err != nil {
 		logger.Debug(err.Error())
 		return err
 	}
 
+	// cross check logs with quorum endpoints before writing any
+	if len(d.quorumEndpoints) > 0 {
+		head, err := client.HeaderByNumber(context.TODO(), query.ToBlock)
+		if err != nil {
+			logger.Debug("get block header error: ", err)
+			return err
+		}
+		err = d.crossCheck(context.TODO(), query, events, head)
+		if err != nil {
+			logger.Debug("quorum check error: ", err.Error())
+			return err
+		}
+	}
+
+	// verify logs against receipts roots of their blocks
+	var verifyErrs map[common.Hash]error
+	if d.verifyReceipts {
+		verifyErrs = verifyLogs(context.TODO(), client, events)
+	}
+
 	// record block
 	lastBlock := d.fromBlock
 
-	// parse each event
+	complete := d.applyLogs(context.TODO(), client, events, verifyErrs)
+
+	// blocks without logs up to the chain block are indexed too
+	if complete && d.fromBlock.Uint64() <= chainBlock {
+		d.fromBlock = new(big.Int).SetUint64(chainBlock + 1)
+	}
+
+	// update block in db
+	if d.fromBlock.Cmp(lastBlock) > 0 {
+		database.SetBlockNumber(d.fromBlock.Int64())
+	}
+
+	// notify scheduler with the last indexed block, the chain head may not be reached
+	if d.fromBlock.Sign() > 0 {
+		head, err := client.HeaderByNumber(context.TODO(), new(big.Int).Sub(d.fromBlock, big.NewInt(1)))
+		if err != nil {
+			logger.Debug("get block header error: ", err)
+			return err
+		}
+		d.notifyHead(head)
+	}
+
+	return nil
+}
+
+// position of a log in the chain
+type logCursor struct {
+	set   bool
+	block uint64
+	index uint
+}
+
+// whether the log is at or before the cursor
+func (c logCursor) covers(log types.Log) bool {
+	return c.set && (log.BlockNumber < c.block || (log.BlockNumber == c.block && log.Index <= c.index))
+}
+
+// move the cursor past a log which is not applied again
+func (d *Dumper) skipLog(log types.Log) {
+	err := database.SetLogCursor(log.BlockNumber, log.Index)
+	if err != nil {
+		logger.Debug("set log cursor error: ", err.Error())
+	}
+	d.applied = logCursor{set: true, block: log.BlockNumber, index: log.Index}
+}
+
+// apply logs in order and move fromBlock past them, return false if the range is not fully indexed
+func (d *Dumper) applyLogs(ctx context.Context, client txReader, events []types.Log, verifyErrs map[common.Hash]error) bool {
+	// parse each event, complete is unset if the range is not fully indexed
+	complete := true
 	for _, event := range events {
-		// topic0 is the event name
-		eventName, ok1 := d.eventNameMap[event.Topics[0]]
-		if !ok1 {
+		// stop before a block not verified, it is fetched again in the next round
+		if err := verifyErrs[event.BlockHash]; err != nil {
+			logger.Warn("verify logs of block ", event.BlockNumber, " error: ", err.Error())
+			complete = false
+			break
+		}
+
+		// logs up to the cursor were applied before a halt or restart
+		if d.applied.covers(event) {
 			continue
 		}
 
-		switch eventName {
-		case "Register":
-			logger.Debug("==== Handle Register Event")
-			err = d.HandleRegister(event)
+		// route by emitting contract and topic0
+		ev, ok := d.match(event)
+		if !ok {
+			err := d.storeUnhandled(event, ContractEvent{})
 			if err != nil {
-				logger.Debug("handle register error: ", err.Error())
-			}
-		case "AddNode":
-			logger.Debug("==== Handle Add Node Event")
-			err = d.HandleAddNode(event)
-			if err != nil {
-				logger.Debug("handle addNode error: ", err.Error())
-			}
-		case "DelNode":
-			logger.Debug("==== Handle Delete Node Event")
-			err = d.HandleDelNode(event)
-			if err != nil {
-				logger.Debug("handle delNode error: ", err.Error())
-			}
-		case "CreateOrder":
-			logger.Debug("==== Handle Create Order Event")
-			tx, _, err := client.TransactionByHash(context.TODO(), event.TxHash)
-			if err != nil {
-				logger.Debug("handle create order error: ", err.Error())
+				logger.Debug("store unhandled log error: ", err.Error())
 			}
+			d.skipLog(event)
+			continue
+		}
 
-			// get user address
-			address, err := types.LatestSignerForChainID(tx.ChainId()).Sender(tx)
+		// a halted log waits for its backoff, it is fetched again in the next round
+		settled, wait := d.haltedState(event)
+		if wait {
+			d.fromBlock = new(big.Int).SetUint64(event.BlockNumber)
+			complete = false
+			break
+		}
+
+		// apply with built-in handlers and declared mappings, failures go to the dead letter queue
+		var (
+			handled = true
+			err     error
+		)
+		if !settled {
+			handled, err = d.handle(ctx, client, ev)
+		}
+		halt := false
+		switch {
+		case err != nil:
+			halt, err = d.deadLetter(ev, err)
 			if err != nil {
-				logger.Debug(err.Error())
-				return err
+				logger.Debug("store dead letter error: ", err.Error())
 			}
-
-			// store order info
-			err = d.HandleCreateOrder(event, address)
+		case !handled:
+			err = d.storeUnhandled(event, ev)
 			if err != nil {
-				logger.Debug(err.Error())
+				logger.Debug("store unhandled ", ev.Name, " error: ", err.Error())
 			}
-		default:
-			continue
 		}
 
+		// the block is fetched again in the next round, from the halted log
+		if halt {
+			d.fromBlock = new(big.Int).SetUint64(event.BlockNumber)
+			complete = false
+			break
+		}
+
+		// an applied log moved the cursor within its transaction
+		d.skipLog(event)
+
 		// start from next block
 		if event.BlockNumber >= d.fromBlock.Uint64() {
 			d.fromBlock = big.NewInt(int64(event.BlockNumber) + 1)
 		}
 	}
 
-	// update block in db
-	if d.fromBlock.Cmp(lastBlock) > 0 {
-		database.SetBlockNumber(d.fromBlock.Int64())
-	}
-
-	return nil
+	return complete
 }
 
-// unpack a log
-func (d *Dumper) unpack(log types.Log, ABI abi.ABI, out interface{}) error {
-	// get event name from map with hash
-	eventName := d.eventNameMap[log.Topics[0]]
-	// get all topics
-	indexed := d.indexedMap[log.Topics[0]]
+// source of the transactions of logs, satisfied by ethclient
+type txReader interface {
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+}
 
-	//logger.Debug("log data: %x", log.Data)
-	//logger.Debug("log topics: ", log.Topics)
+// apply an event with its built-in handler and mappings in one transaction, false if neither takes it
+func (d *Dumper) handle(ctx context.Context, client txReader, ev ContractEvent) (bool, error) {
+	d.handleLk.Lock()
+	defer d.handleLk.Unlock()
+
+	// get user address of an order before writing
+	var from common.Address
+	if ev.key() == (eventKey{MarketContract, "CreateOrder"}) {
+		tx, _, err := client.TransactionByHash(ctx, ev.Log.TxHash)
+		if err != nil {
+			return true, xerrors.Errorf("get create order tx: %w", err)
+		}
+		from, err = types.LatestSignerForChainID(tx.ChainId()).Sender(tx)
+		if err != nil {
+			return true, xerrors.Errorf("get create order sender: %w", err)
+		}
+	}
 
-	// logger.Info("event name: ", eventName)
-	//logger.Debug("topics in map: ", indexed)
+	handled := true
+	err := database.GlobalDataBase.Transaction(func(tx *gorm.DB) error {
+		var err error
+		switch ev.key() {
+		case eventKey{RegistryContract, "Register"}:
+			logger.Debug("==== Handle Register Event")
+			err = d.HandleRegister(tx, ev)
+		case eventKey{RegistryContract, "AddNode"}:
+			logger.Debug("==== Handle Add Node Event")
+			err = d.HandleAddNode(tx, ev)
+		case eventKey{RegistryContract, "DelNode"}:
+			logger.Debug("==== Handle Delete Node Event")
+			err = d.HandleDelNode(tx, ev)
+		case eventKey{MarketContract, "CreateOrder"}:
+			logger.Debug("==== Handle Create Order Event")
+			err = d.HandleCreateOrder(tx, ev, from)
+		case eventKey{MarketContract, "Withdraw"}:
+			logger.Debug("==== Handle Withdraw Event")
+			err = d.HandleWithdraw(tx, ev)
+		default:
+			handled = false
+		}
+		if err != nil {
+			return xerrors.Errorf("handle %s: %w", ev.Name, err)
+		}
 
-	// parse data
-	//logger.Debug("parse data, event name: ", eventName)
-	err := ABI.UnpackIntoInterface(out, eventName, log.Data)
-	if err != nil {
-		return err
-	}
-	//logger.Debug("unpack out(no topics):", out)
+		mapped, err := d.handleMapped(tx, ev)
+		if err != nil {
+			return xerrors.Errorf("handle mapped %s: %w", ev.Name, err)
+		}
+		handled = handled || mapped
 
-	// parse topic
-	//logger.Debug("parse topic")
-	err = abi.ParseTopics(out, indexed, log.Topics[1:])
+		// the log is not applied again after a restart
+		return database.SetLogCursorTx(tx, ev.Log.BlockNumber, ev.Log.Index)
+	})
 	if err != nil {
-		return err
+		return true, err
 	}
-	//logger.Debug("unpack out(with topics):", out)
 
-	return nil
+	return handled, nil
 }
 
 // func recoverAddressFromTx(tx *types.Transaction) (common.Address, error) {
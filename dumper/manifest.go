@@ -0,0 +1,324 @@
+package dumper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// Manifest declares how events of contracts are mapped onto generic entity tables,
+// events are handled by the mappings and any built-in handler in one transaction
+type Manifest struct {
+	Contracts []ManifestContract `json:"contracts" yaml:"contracts"`
+}
+
+// ManifestContract is a watched contract in a manifest.
+// registry and market are referred by name only, other contracts need an address and an abi file
+type ManifestContract struct {
+	Name       string         `json:"name" yaml:"name"`
+	Address    string         `json:"address" yaml:"address"`
+	ABI        string         `json:"abi" yaml:"abi"`
+	StartBlock uint64         `json:"startBlock" yaml:"startBlock"`
+	Events     []EventMapping `json:"events" yaml:"events"`
+}
+
+// EventMapping maps an event onto an entity.
+// key lists the sources joined into the row key, an insert without key gets a row per log.
+// fields map entity fields to sources, all event arguments if empty.
+// a source is an event argument or one of $block, $tx, $logIndex, $address
+type EventMapping struct {
+	Event  string            `json:"event" yaml:"event"`
+	Entity string            `json:"entity" yaml:"entity"`
+	Action string            `json:"action" yaml:"action"`
+	Key    []string          `json:"key" yaml:"key"`
+	Fields map[string]string `json:"fields" yaml:"fields"`
+}
+
+// a mapping of an event active from the start block of its contract
+type mapping struct {
+	EventMapping
+	startBlock uint64
+}
+
+// load a manifest from a json or yaml file, relative abi paths are resolved from the manifest directory
+func LoadManifest(path string) (Manifest, error) {
+	var m Manifest
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return m, xerrors.Errorf("parse manifest %s: %w", path, err)
+	}
+
+	for i, c := range m.Contracts {
+		if c.ABI != "" && !filepath.IsAbs(c.ABI) {
+			m.Contracts[i].ABI = filepath.Join(filepath.Dir(path), c.ABI)
+		}
+	}
+
+	return m, nil
+}
+
+// watch contracts of a manifest and register its mappings
+func (d *Dumper) addManifest(m Manifest) error {
+	for _, mc := range m.Contracts {
+		c, err := d.contractByName(mc.Name)
+		switch {
+		case err == nil:
+			if mc.Address != "" || mc.ABI != "" {
+				return xerrors.Errorf("contract %s is watched, remove its address and abi", mc.Name)
+			}
+		case mc.Name == "" || !common.IsHexAddress(mc.Address) || mc.ABI == "":
+			return xerrors.Errorf("contract %q needs a name, address and abi", mc.Name)
+		default:
+			data, err := LoadABIFile(mc.ABI)
+			if err != nil {
+				return err
+			}
+			ABI, err := parseContractABI(data)
+			if err != nil {
+				return xerrors.Errorf("abi %s: %w", mc.ABI, err)
+			}
+
+			address := common.HexToAddress(mc.Address)
+			if _, ok := d.contracts[address]; ok {
+				return xerrors.Errorf("address %s of %s is watched", address.Hex(), mc.Name)
+			}
+			c = newContract(mc.Name, address)
+			c.addVersion(mc.StartBlock, ABI)
+			d.contracts[address] = c
+			d.contractAddress = append(d.contractAddress, address)
+		}
+
+		for _, em := range mc.Events {
+			err = c.checkMapping(em)
+			if err != nil {
+				return xerrors.Errorf("contract %s: %w", mc.Name, err)
+			}
+
+			k := eventKey{mc.Name, em.Event}
+			d.mappings[k] = append(d.mappings[k], mapping{EventMapping: em, startBlock: mc.StartBlock})
+		}
+	}
+
+	return nil
+}
+
+// check a mapping against the events of all abi versions
+func (c *contract) checkMapping(em EventMapping) error {
+	switch em.Action {
+	case database.EntityInsert:
+	case database.EntityUpsert, database.EntityUpdate, database.EntityIncrement:
+		if len(em.Key) == 0 {
+			return xerrors.Errorf("%s of %s needs a key", em.Action, em.Entity)
+		}
+	default:
+		return xerrors.Errorf("unknown action %q of %s", em.Action, em.Event)
+	}
+	if em.Entity == "" {
+		return xerrors.Errorf("event %s is not mapped to an entity", em.Event)
+	}
+
+	for _, v := range c.versions {
+		event, ok := v.abi.Events[em.Event]
+		if !ok {
+			continue
+		}
+
+		sources := append([]string{}, em.Key...)
+		for _, s := range em.Fields {
+			sources = append(sources, s)
+		}
+		for _, s := range sources {
+			if strings.HasPrefix(s, "$") {
+				continue
+			}
+			if !hasArgument(event.Inputs, s) {
+				return xerrors.Errorf("event %s has no argument %s", em.Event, s)
+			}
+		}
+
+		return nil
+	}
+
+	return xerrors.Errorf("no event %s", em.Event)
+}
+
+func hasArgument(args abi.Arguments, name string) bool {
+	for _, arg := range args {
+		if arg.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply the mappings of an event in a transaction, false if the event is not mapped
+func (d *Dumper) handleMapped(tx *gorm.DB, ev ContractEvent) (bool, error) {
+	var active []mapping
+	for _, m := range d.mappings[ev.key()] {
+		if ev.Log.BlockNumber >= m.startBlock {
+			active = append(active, m)
+		}
+	}
+	if len(active) == 0 {
+		return false, nil
+	}
+
+	args, err := ev.UnpackMap()
+	if err != nil {
+		return true, err
+	}
+	source := func(s string) (interface{}, error) {
+		switch s {
+		case "$block":
+			return ev.Log.BlockNumber, nil
+		case "$tx":
+			return ev.Log.TxHash.Hex(), nil
+		case "$logIndex":
+			return ev.Log.Index, nil
+		case "$address":
+			return ev.Log.Address.Hex(), nil
+		}
+
+		v, ok := args[s]
+		if !ok {
+			return nil, xerrors.Errorf("event %s has no argument %s", ev.Name, s)
+		}
+		return v, nil
+	}
+
+	for _, m := range active {
+		key := fmt.Sprintf("%s/%d", ev.Log.TxHash.Hex(), ev.Log.Index)
+		if len(m.Key) > 0 {
+			parts := make([]string, len(m.Key))
+			for i, s := range m.Key {
+				v, err := source(s)
+				if err != nil {
+					return true, err
+				}
+				parts[i] = fmt.Sprint(v)
+			}
+			key = strings.Join(parts, "/")
+		}
+
+		fields := args
+		if len(m.Fields) > 0 {
+			fields = make(map[string]interface{}, len(m.Fields))
+			for f, s := range m.Fields {
+				fields[f], err = source(s)
+				if err != nil {
+					return true, err
+				}
+			}
+		}
+
+		err = database.ApplyEntityTx(tx, m.Action, m.Entity, key, fields, ev.Log.BlockNumber, ev.Log.TxHash.Hex())
+		if err != nil {
+			return true, xerrors.Errorf("%s %s: %w", m.Action, m.Entity, err)
+		}
+	}
+
+	return true, nil
+}
+
+// unpack data and indexed topics of the event into values by argument name,
+// big ints are decimal strings, addresses and bytes are hex strings
+func (e ContractEvent) UnpackMap() (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	err := e.v.abi.UnpackIntoMap(raw, e.Name, e.Log.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = abi.ParseTopicsIntoMap(raw, e.v.indexed[e.Log.Topics[0]], e.Log.Topics[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		out[k] = plainValue(reflect.ValueOf(v))
+	}
+
+	return out, nil
+}
+
+// convert a decoded abi value into json friendly values
+func plainValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch x := v.Interface().(type) {
+	case *big.Int:
+		if x == nil {
+			return nil
+		}
+		return x.String()
+	case common.Address:
+		return x.Hex()
+	case common.Hash:
+		return x.Hex()
+	case []byte:
+		return hexutil.Encode(x)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return plainValue(v.Elem())
+	case reflect.Array:
+		// fixed bytes
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return hexutil.Encode(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = plainValue(v.Index(i))
+		}
+		return out
+	case reflect.Struct:
+		// tuples, fields keep the abi names
+		out := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Name
+			if tag := v.Type().Field(i).Tag.Get("json"); tag != "" {
+				name = tag
+			}
+			out[name] = plainValue(v.Field(i))
+		}
+		return out
+	}
+
+	return v.Interface()
+}
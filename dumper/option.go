@@ -0,0 +1,109 @@
+package dumper
+
+import (
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+)
+
+// Option configures a dumper
+type Option func(*Dumper)
+
+// take a utilization snapshot every n indexed blocks, 0 to disable
+func WithSnapshotBlocks(n uint64) Option {
+	return func(d *Dumper) {
+		d.snapshotBlocks = n
+	}
+}
+
+// take a utilization snapshot every interval of chain time, 0 to disable
+func WithSnapshotInterval(interval time.Duration) Option {
+	return func(d *Dumper) {
+		d.snapshotInterval = interval
+	}
+}
+
+// downsample snapshots older than age to resolution res
+func WithSnapshotRetention(age time.Duration, res database.Resolution) Option {
+	return func(d *Dumper) {
+		d.snapshotRetention = age
+		d.snapshotResolution = res
+	}
+}
+
+// settle order remuneration every interval of chain time, ended orders are always settled
+func WithSettleInterval(interval time.Duration) Option {
+	return func(d *Dumper) {
+		d.settleInterval = interval
+	}
+}
+
+// compute provider reputations every interval of chain time with weights w, 0 to disable
+func WithReputation(interval time.Duration, w database.ReputationWeights) Option {
+	return func(d *Dumper) {
+		d.reputationInterval = interval
+		d.reputationWeights = w
+	}
+}
+
+// build a merkle root over provider balances every n indexed blocks, 0 to disable
+func WithBalanceRootBlocks(n uint64) Option {
+	return func(d *Dumper) {
+		d.balanceRootBlocks = n
+	}
+}
+
+// seed an empty database from contract state at block n instead of replaying all events, 0 for the chain head.
+// the abis active at n must declare the views read, the default abis declare none
+func WithBootstrap(n uint64) Option {
+	return func(d *Dumper) {
+		d.bootstrap = true
+		d.bootstrapBlock = n
+	}
+}
+
+// apply logs only if their block receipts and transactions match the roots of the header
+func WithReceiptVerification() Option {
+	return func(d *Dumper) {
+		d.verifyReceipts = true
+	}
+}
+
+// cross check logs and block hashes of each round with other endpoints, indexing halts on disagreement
+func WithQuorumEndpoints(endpoints ...string) Option {
+	return func(d *Dumper) {
+		d.quorumEndpoints = endpoints
+	}
+}
+
+// index and cross check blocks n below the chain head with quorum endpoints, 6 by default
+func WithQuorumConfirmations(n uint64) Option {
+	return func(d *Dumper) {
+		d.quorumConfirmations = n
+	}
+}
+
+// decode logs of a contract from block n with the abi in a json file, a plain abi array or a build artifact.
+// logs before n use older versions, a file at block 0 replaces the default abi
+func WithABIFile(contract string, n uint64, path string) Option {
+	return func(d *Dumper) {
+		d.abiFiles = append(d.abiFiles, abiFile{contract: contract, fromBlock: n, path: path})
+	}
+}
+
+// map events onto generic entity tables with a json or yaml manifest, see Manifest
+func WithManifest(path string) Option {
+	return func(d *Dumper) {
+		d.manifests = append(d.manifests, path)
+	}
+}
+
+// set the policy of events whose handler failed, they are retried up to maxAttempts with
+// a delay doubling from backoff, with DeadLetterHalt the cursor also halts at them meanwhile
+func WithDeadLetter(policy DeadLetterPolicy, maxAttempts uint64, backoff time.Duration) Option {
+	return func(d *Dumper) {
+		d.deadLetterPolicy = policy
+		d.deadLetterMaxAttempts = maxAttempts
+		d.deadLetterBackoff = backoff
+	}
+}
@@ -0,0 +1,119 @@
+package dumper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"golang.org/x/xerrors"
+)
+
+// ErrQuorumHalted is returned by DumpGRID after endpoints disagreed, no data is written any more
+var ErrQuorumHalted = xerrors.New("dumper halted by quorum mismatch")
+
+// QuorumAlert is sent when a quorum endpoint returns different logs or block hash
+type QuorumAlert struct {
+	Endpoint  string
+	FromBlock uint64
+	ToBlock   uint64
+	Tip       uint64 // confirmed block whose hash was compared
+	Reason    string
+}
+
+// subscribe quorum alerts, the channel should be buffered or drained quickly
+func (d *Dumper) SubscribeQuorumAlert(ch chan<- QuorumAlert) event.Subscription {
+	return d.quorumFeed.Subscribe(ch)
+}
+
+// query the same range from all quorum endpoints and compare with the primary result.
+// the dumper is halted if any endpoint disagrees, an unreachable endpoint only fails this round
+func (d *Dumper) crossCheck(ctx context.Context, query ethereum.FilterQuery, logs []types.Log, head *types.Header) error {
+	for _, ep := range d.quorumEndpoints {
+		reason, err := crossCheckEndpoint(ctx, ep, query, logs, head)
+		if err != nil {
+			return xerrors.Errorf("quorum endpoint %s: %w", ep, err)
+		}
+		if reason == "" {
+			continue
+		}
+
+		d.halted = true
+		alert := QuorumAlert{
+			Endpoint:  ep,
+			FromBlock: query.FromBlock.Uint64(),
+			ToBlock:   query.ToBlock.Uint64(),
+			Tip:       head.Number.Uint64(),
+			Reason:    reason,
+		}
+		logger.Errorw("quorum mismatch, dumper halted", "endpoint", alert.Endpoint, "from", alert.FromBlock, "to", alert.ToBlock, "tip", alert.Tip, "reason", alert.Reason)
+		d.quorumFeed.Send(alert)
+
+		return ErrQuorumHalted
+	}
+
+	return nil
+}
+
+// return the disagreement of an endpoint, empty if it agrees
+func crossCheckEndpoint(ctx context.Context, ep string, query ethereum.FilterQuery, logs []types.Log, head *types.Header) (string, error) {
+	client, err := ethclient.DialContext(ctx, ep)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	other, err := client.HeaderByNumber(ctx, head.Number)
+	if err != nil {
+		return "", err
+	}
+	if other.Hash() != head.Hash() {
+		return fmt.Sprintf("block %d hash %s, primary %s", head.Number, other.Hash(), head.Hash()), nil
+	}
+
+	otherLogs, err := client.FilterLogs(ctx, query)
+	if err != nil {
+		return "", err
+	}
+
+	return compareLogs(logs, otherLogs), nil
+}
+
+// compare two log sets by tx hash, log index, block hash, address, topics and data
+func compareLogs(a, b []types.Log) string {
+	type key struct {
+		block uint64
+		index uint
+	}
+
+	set := make(map[key]types.Log, len(a))
+	for _, l := range a {
+		set[key{l.BlockNumber, l.Index}] = l
+	}
+
+	for _, l := range b {
+		k := key{l.BlockNumber, l.Index}
+		p, ok := set[k]
+		if !ok {
+			return fmt.Sprintf("log %d/%d missing in primary", l.BlockNumber, l.Index)
+		}
+		delete(set, k)
+
+		switch {
+		case p.TxHash != l.TxHash:
+			return fmt.Sprintf("log %d/%d tx hash %s, primary %s", l.BlockNumber, l.Index, l.TxHash, p.TxHash)
+		case p.BlockHash != l.BlockHash:
+			return fmt.Sprintf("log %d/%d block hash %s, primary %s", l.BlockNumber, l.Index, l.BlockHash, p.BlockHash)
+		case !sameLog(&p, &l):
+			return fmt.Sprintf("log %d/%d content differs", l.BlockNumber, l.Index)
+		}
+	}
+
+	for k := range set {
+		return fmt.Sprintf("log %d/%d missing in endpoint", k.block, k.index)
+	}
+
+	return ""
+}
@@ -0,0 +1,186 @@
+package dumper
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/xerrors"
+)
+
+type providerView struct {
+	Name   string
+	Ip     string
+	Domain string
+	Port   string
+}
+
+type nodeView struct {
+	CpuPriceMon  *big.Int
+	CpuPriceSec  *big.Int
+	CpuModel     string
+	CpuCore      uint64
+	GpuPriceMon  *big.Int
+	GpuPriceSec  *big.Int
+	GpuModel     string
+	MemPriceMon  *big.Int
+	MemPriceSec  *big.Int
+	MemNum       uint64
+	DiskPriceMon *big.Int
+	DiskPriceSec *big.Int
+	DiskNum      uint64
+	Exist        bool
+	Sold         bool
+	Avail        bool
+}
+
+// withdrawable balance of a provider and the number of its withdrawals
+type balanceView struct {
+	Balance *big.Int
+	Nonce   uint64
+}
+
+type orderView struct {
+	User   common.Address
+	Cp     common.Address
+	Nid    uint64
+	Act    *big.Int
+	Pro    *big.Int
+	Dur    *big.Int
+	Status uint8
+}
+
+// reads registry and market state with view calls at a block
+type contractReader struct {
+	caller ethereum.ContractCaller
+
+	registryAddress common.Address
+	marketAddress   common.Address
+	registryABI     abi.ABI
+	marketABI       abi.ABI
+}
+
+// views called by the reader. the bindings of grid-contracts declare none of them,
+// so the abis must be loaded from files of contract versions exposing them
+var (
+	registryViews = []string{"getProviders", "getProvider", "getNodeIds", "getNode"}
+	marketViews   = []string{"getOrderIds", "getOrder", "getBalance"}
+)
+
+func newContractReader(caller ethereum.ContractCaller, registryAddress, marketAddress common.Address, registryABI, marketABI abi.ABI) (*contractReader, error) {
+	err := requireViews(RegistryContract, registryABI, registryViews)
+	if err != nil {
+		return nil, err
+	}
+	err = requireViews(MarketContract, marketABI, marketViews)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contractReader{
+		caller:          caller,
+		registryAddress: registryAddress,
+		marketAddress:   marketAddress,
+		registryABI:     registryABI,
+		marketABI:       marketABI,
+	}, nil
+}
+
+// check the abi of a contract declares the views
+func requireViews(name string, ABI abi.ABI, views []string) error {
+	for _, view := range views {
+		if _, ok := ABI.Methods[view]; !ok {
+			return xerrors.Errorf("abi of %s has no view %s, load an abi file of a contract declaring it", name, view)
+		}
+	}
+
+	return nil
+}
+
+// call a view function at a block and unpack its outputs
+func (r *contractReader) call(ctx context.Context, to common.Address, ABI abi.ABI, blockNumber *big.Int, out interface{}, method string, args ...interface{}) error {
+	data, err := ABI.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, blockNumber)
+	if err != nil {
+		return err
+	}
+
+	return ABI.UnpackIntoInterface(out, method, res)
+}
+
+// call a view function with a single output
+func (r *contractReader) call1(ctx context.Context, to common.Address, ABI abi.ABI, blockNumber *big.Int, method string, args ...interface{}) (interface{}, error) {
+	data, err := ABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := ABI.Unpack(method, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return out[0], nil
+}
+
+func (r *contractReader) getProvider(ctx context.Context, blockNumber *big.Int, cp common.Address) (providerView, error) {
+	var view providerView
+	err := r.call(ctx, r.registryAddress, r.registryABI, blockNumber, &view, "getProvider", cp)
+	return view, err
+}
+
+func (r *contractReader) getNode(ctx context.Context, blockNumber *big.Int, cp common.Address, id uint64) (nodeView, error) {
+	var view nodeView
+	err := r.call(ctx, r.registryAddress, r.registryABI, blockNumber, &view, "getNode", cp, id)
+	return view, err
+}
+
+func (r *contractReader) getOrder(ctx context.Context, blockNumber *big.Int, id uint64) (orderView, error) {
+	var view orderView
+	err := r.call(ctx, r.marketAddress, r.marketABI, blockNumber, &view, "getOrder", id)
+	return view, err
+}
+
+func (r *contractReader) getBalance(ctx context.Context, blockNumber *big.Int, cp common.Address) (balanceView, error) {
+	var view balanceView
+	err := r.call(ctx, r.marketAddress, r.marketABI, blockNumber, &view, "getBalance", cp)
+	return view, err
+}
+
+func (r *contractReader) getProviders(ctx context.Context, blockNumber *big.Int) ([]common.Address, error) {
+	out, err := r.call1(ctx, r.registryAddress, r.registryABI, blockNumber, "getProviders")
+	if err != nil {
+		return nil, err
+	}
+
+	return *abi.ConvertType(out, new([]common.Address)).(*[]common.Address), nil
+}
+
+func (r *contractReader) getNodeIds(ctx context.Context, blockNumber *big.Int, cp common.Address) ([]uint64, error) {
+	out, err := r.call1(ctx, r.registryAddress, r.registryABI, blockNumber, "getNodeIds", cp)
+	if err != nil {
+		return nil, err
+	}
+
+	return *abi.ConvertType(out, new([]uint64)).(*[]uint64), nil
+}
+
+func (r *contractReader) getOrderIds(ctx context.Context, blockNumber *big.Int) ([]uint64, error) {
+	out, err := r.call1(ctx, r.marketAddress, r.marketABI, blockNumber, "getOrderIds")
+	if err != nil {
+		return nil, err
+	}
+
+	return *abi.ConvertType(out, new([]uint64)).(*[]uint64), nil
+}
@@ -0,0 +1,101 @@
+package dumper
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/xerrors"
+)
+
+// source of blocks and receipts, satisfied by ethclient
+type receiptsReader interface {
+	BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error)
+	BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error)
+}
+
+// verify logs against the receipts root of their blocks, return the error of each block
+func verifyLogs(ctx context.Context, r receiptsReader, logs []types.Log) map[common.Hash]error {
+	blocks := make(map[common.Hash][]types.Log)
+	for _, l := range logs {
+		blocks[l.BlockHash] = append(blocks[l.BlockHash], l)
+	}
+
+	errs := make(map[common.Hash]error)
+	for hash, ls := range blocks {
+		errs[hash] = verifyBlockLogs(ctx, r, hash, ls)
+	}
+
+	return errs
+}
+
+// rebuild the transactions and receipts tries of a block, check them against the header and find each log in the receipts.
+// tx hashes and log indexes are not in the receipts trie, so they are taken from the transactions and the receipt order.
+// the header itself is only checked against its hash, not against consensus
+func verifyBlockLogs(ctx context.Context, r receiptsReader, blockHash common.Hash, logs []types.Log) error {
+	block, err := r.BlockByHash(ctx, blockHash)
+	if err != nil {
+		return err
+	}
+	header := block.Header()
+	if header.Hash() != blockHash {
+		return xerrors.Errorf("header hash %s mismatch block %s", header.Hash(), blockHash)
+	}
+
+	txs := block.Transactions()
+	txRoot := types.DeriveSha(txs, trie.NewStackTrie(nil))
+	if txRoot != header.TxHash {
+		return xerrors.Errorf("transactions root %s mismatch header %s of block %s", txRoot, header.TxHash, blockHash)
+	}
+
+	receipts, err := r.BlockReceipts(ctx, rpc.BlockNumberOrHashWithHash(blockHash, true))
+	if err != nil {
+		return err
+	}
+
+	root := types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil))
+	if root != header.ReceiptHash {
+		return xerrors.Errorf("receipts root %s mismatch header %s of block %s", root, header.ReceiptHash, blockHash)
+	}
+	if len(receipts) != len(txs) {
+		return xerrors.Errorf("block %s has %d receipts of %d transactions", blockHash, len(receipts), len(txs))
+	}
+
+	// index of the first log of each receipt in the block
+	first := make([]uint, len(receipts))
+	var n uint
+	for i, receipt := range receipts {
+		first[i] = n
+		n += uint(len(receipt.Logs))
+	}
+
+	for _, l := range logs {
+		if int(l.TxIndex) >= len(txs) || txs[l.TxIndex].Hash() != l.TxHash {
+			return xerrors.Errorf("log %s/%d has no transaction", l.TxHash, l.Index)
+		}
+
+		rls := receipts[l.TxIndex].Logs
+		if l.Index < first[l.TxIndex] || l.Index-first[l.TxIndex] >= uint(len(rls)) || !sameLog(rls[l.Index-first[l.TxIndex]], &l) {
+			return xerrors.Errorf("log %s/%d not found in receipt", l.TxHash, l.Index)
+		}
+	}
+
+	return nil
+}
+
+// compare consensus fields of two logs
+func sameLog(a, b *types.Log) bool {
+	if a.Address != b.Address || len(a.Topics) != len(b.Topics) || !bytes.Equal(a.Data, b.Data) {
+		return false
+	}
+	for i := range a.Topics {
+		if a.Topics[i] != b.Topics[i] {
+			return false
+		}
+	}
+
+	return true
+}
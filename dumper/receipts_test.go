@@ -0,0 +1,112 @@
+package dumper
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// fakeReceipts serves a block and its receipts, which may be tampered
+type fakeReceipts struct {
+	block    *types.Block
+	receipts []*types.Receipt
+}
+
+func (f *fakeReceipts) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	return f.block, nil
+}
+
+func (f *fakeReceipts) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	return f.receipts, nil
+}
+
+// a block of three transactions, the first and the last emit a log each
+func testBlock() (*types.Block, []*types.Receipt, []types.Log) {
+	var txs []*types.Transaction
+	var receipts []*types.Receipt
+	for i := 0; i < 3; i++ {
+		tx := types.NewTx(&types.LegacyTx{Nonce: uint64(i), Gas: 21000, GasPrice: big.NewInt(1), To: &testMarket, Value: big.NewInt(1)})
+		receipt := &types.Receipt{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: uint64(i+1) * 21000, TxHash: tx.Hash(), TransactionIndex: uint(i)}
+		if i != 1 {
+			receipt.Logs = []*types.Log{{Address: testMarket, Topics: []common.Hash{common.BigToHash(big.NewInt(int64(i)))}, Data: []byte{byte(i)}}}
+		}
+		txs = append(txs, tx)
+		receipts = append(receipts, receipt)
+	}
+
+	block := types.NewBlock(&types.Header{Number: big.NewInt(10)}, &types.Body{Transactions: txs}, receipts, trie.NewStackTrie(nil))
+
+	var logs []types.Log
+	var index uint
+	for i, receipt := range receipts {
+		for _, l := range receipt.Logs {
+			l.BlockHash, l.TxHash, l.TxIndex, l.Index = block.Hash(), receipt.TxHash, uint(i), index
+			logs = append(logs, *l)
+			index++
+		}
+	}
+
+	return block, receipts, logs
+}
+
+func TestVerifyBlockLogs(t *testing.T) {
+	block, receipts, logs := testBlock()
+	ctx := context.Background()
+
+	err := verifyBlockLogs(ctx, &fakeReceipts{block, receipts}, block.Hash(), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the log data differs from the receipt
+	l := logs[1]
+	l.Data = []byte{9}
+	err = verifyBlockLogs(ctx, &fakeReceipts{block, receipts}, block.Hash(), []types.Log{l})
+	if err == nil {
+		t.Fatal("log not in the receipt verified")
+	}
+
+	// a receipt with other logs does not match the receipts root
+	tampered := make([]*types.Receipt, len(receipts))
+	copy(tampered, receipts)
+	r := *receipts[2]
+	r.Logs = []*types.Log{{Address: testMarket, Data: []byte{9}}}
+	tampered[2] = &r
+	err = verifyBlockLogs(ctx, &fakeReceipts{block, tampered}, block.Hash(), logs)
+	if err == nil {
+		t.Fatal("tampered receipt verified")
+	}
+
+	// tx hashes are not in the receipts trie, a log moved to another tx is caught by the transactions
+	copy(tampered, receipts)
+	r = *receipts[2]
+	r.TxHash = common.HexToHash("0x01")
+	tampered[2] = &r
+	l = logs[1]
+	l.TxHash = r.TxHash
+	err = verifyBlockLogs(ctx, &fakeReceipts{block, tampered}, block.Hash(), []types.Log{l})
+	if err == nil {
+		t.Fatal("log of a tampered tx hash verified")
+	}
+
+	// log indexes count the logs of the receipts before
+	l = logs[1]
+	l.Index = 0
+	err = verifyBlockLogs(ctx, &fakeReceipts{block, receipts}, block.Hash(), []types.Log{l})
+	if err == nil {
+		t.Fatal("log with a wrong index verified")
+	}
+
+	// a body of other transactions does not match the transactions root
+	other, _, _ := testBlock()
+	body := types.Body{Transactions: append(other.Transactions()[:2:2], types.NewTx(&types.LegacyTx{Nonce: 9}))}
+	err = verifyBlockLogs(ctx, &fakeReceipts{block.WithBody(body), receipts}, block.Hash(), logs)
+	if err == nil {
+		t.Fatal("tampered transactions verified")
+	}
+}
@@ -0,0 +1,45 @@
+package dumper
+
+import (
+	"context"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+)
+
+// reconcile global counters with base tables every interval, drift is repaired if repair is set
+func (d *Dumper) SubscribeReconcile(ctx context.Context, interval time.Duration, repair bool) {
+	for {
+		d.Reconcile(repair)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// reconcile global counters once and report drift
+func (d *Dumper) Reconcile(repair bool) (database.GlobalDrift, error) {
+	drift, err := database.ReconcileGlobal(repair)
+	if err != nil {
+		logger.Debug("reconcile global error: ", err.Error())
+		return drift, err
+	}
+
+	if drift.HasDrift() {
+		logger.Warnw("global counters drift", "stored", drift.Stored, "computed", drift.Computed, "repaired", drift.Repaired)
+	}
+
+	// provider capacities are rebuilt with the same base tables
+	if repair {
+		err = database.RebuildProviderCapacity()
+		if err != nil {
+			logger.Debug("rebuild provider capacity error: ", err.Error())
+			return drift, err
+		}
+	}
+
+	return drift, nil
+}
@@ -1,13 +1,10 @@
 package dumper
 
 import (
-	"math/big"
-	"time"
-
 	"github.com/gridprotocol/dumper/database"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
 )
 
 type RegisterEvent struct {
@@ -19,10 +16,9 @@ type RegisterEvent struct {
 }
 
 // parse a register log
-func (d *Dumper) HandleRegister(log types.Log) error {
+func (d *Dumper) HandleRegister(tx *gorm.DB, ev ContractEvent) error {
 	var out RegisterEvent
-	// abi0 - registry
-	err := d.unpack(log, d.contractABI[0], &out)
+	err := ev.Unpack(&out)
 	if err != nil {
 		return err
 	}
@@ -36,22 +32,16 @@ func (d *Dumper) HandleRegister(log types.Log) error {
 		Port:    out.Port,
 	}
 
-	// save data into db
+	// save data into db, a registered provider is updated
 	logger.Info("store register..")
-	err = providerInfo.CreateProvider()
+	created, err := providerInfo.UpsertProviderTx(tx, ev.Log.BlockNumber, ev.Log.TxHash.Hex())
 	if err != nil {
 		logger.Debug("store register error: ", err.Error())
 		return err
 	}
-
-	now := time.Now()
-	profitInfo := database.Profit{
-		Address:  out.Cp.Hex(),
-		Balance:  big.NewInt(0),
-		Profit:   big.NewInt(0),
-		Penalty:  big.NewInt(0),
-		LastTime: now,
-		EndTime:  now,
+	if !created {
+		logger.Info("provider registered again: ", providerInfo.Address)
 	}
-	return profitInfo.CreateProfit()
+
+	return nil
 }
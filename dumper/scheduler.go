@@ -0,0 +1,213 @@
+package dumper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"gorm.io/gorm"
+)
+
+// OrderExpiredEvent is sent after an ended order is set completed and its node is released
+type OrderExpiredEvent struct {
+	Order       database.Order
+	BlockNumber uint64
+	BlockTime   time.Time
+}
+
+// subscribe order expired events, the channel should be buffered or drained quickly
+func (d *Dumper) SubscribeOrderExpired(ch chan<- OrderExpiredEvent) event.Subscription {
+	return d.expiredFeed.Subscribe(ch)
+}
+
+// pass the latest indexed header to scheduler, an unhandled older header is replaced
+func (d *Dumper) notifyHead(head *types.Header) {
+	for {
+		select {
+		case d.headCh <- head:
+			return
+		default:
+		}
+
+		// drop the stale one
+		select {
+		case <-d.headCh:
+		default:
+		}
+	}
+}
+
+// run tasks on each indexed block until ctx is done
+func (d *Dumper) scheduleBlocks(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case head := <-d.headCh:
+			// settle before expiry, so ended orders get their final period
+			d.runTask("settle orders", head, d.settleOrders)
+			d.runTask("expire orders", head, d.expireOrders)
+			d.runTask("take snapshot", head, d.snapshot)
+			d.runTask("update reputations", head, d.updateReputations)
+			d.runTask("commit balances", head, d.commitBalances)
+		}
+	}
+}
+
+// run a block task serialized with handlers, only if all events up to the block are applied
+func (d *Dumper) runTask(name string, head *types.Header, task func(*types.Header) error) {
+	d.handleLk.Lock()
+	defer d.handleLk.Unlock()
+
+	// the cursor is the next block to index
+	cursor, err := database.GetBlockNumber()
+	if err != nil || head.Number.Int64() >= cursor {
+		logger.Debug(name, " skipped, block not indexed: ", head.Number)
+		return
+	}
+
+	err = task(head)
+	if err != nil {
+		logger.Debug(name, " error: ", err.Error())
+	}
+}
+
+// accrue remuneration of active orders every settle interval of chain time
+func (d *Dumper) settleOrders(head *types.Header) error {
+	blockTime := time.Unix(int64(head.Time), 0)
+
+	orders, err := database.ListSettleableOrders(blockTime, d.settleInterval)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range orders {
+		s, ok, err := database.SettleOrder(o, blockTime, head.Number.Uint64())
+		if err != nil {
+			return err
+		}
+		if ok {
+			logger.Debug("order settled: ", o.Id, " amount: ", s.Amount)
+		}
+	}
+
+	return nil
+}
+
+// set all orders ended before the block completed
+func (d *Dumper) expireOrders(head *types.Header) error {
+	blockTime := time.Unix(int64(head.Time), 0)
+
+	orders, err := database.ListExpiredOrders(blockTime)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range orders {
+		expired, err := database.ExpireOrder(o)
+		if err != nil {
+			return err
+		}
+		if !expired {
+			continue
+		}
+
+		logger.Info("order expired: ", o.Id)
+
+		o.Status = database.OrderCompleted
+		d.expiredFeed.Send(OrderExpiredEvent{
+			Order:       o,
+			BlockNumber: head.Number.Uint64(),
+			BlockTime:   blockTime,
+		})
+	}
+
+	return nil
+}
+
+// take a utilization snapshot if enough blocks or chain time passed since the last one
+func (d *Dumper) snapshot(head *types.Header) error {
+	if d.snapshotBlocks == 0 && d.snapshotInterval == 0 {
+		return nil
+	}
+
+	blockNumber := head.Number.Uint64()
+	blockTime := time.Unix(int64(head.Time), 0)
+
+	last, err := database.GetLastSnapshot()
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err == nil {
+		due := d.snapshotBlocks > 0 && blockNumber >= last.BlockNumber+d.snapshotBlocks
+		due = due || d.snapshotInterval > 0 && !blockTime.Before(last.Time.Add(d.snapshotInterval))
+		if !due {
+			return nil
+		}
+	}
+
+	_, err = database.TakeSnapshot(blockNumber, blockTime)
+	if err != nil {
+		return err
+	}
+
+	// downsample old points
+	if d.snapshotRetention > 0 {
+		n, err := database.CompactSnapshots(blockTime.Add(-d.snapshotRetention), d.snapshotResolution)
+		if err != nil {
+			return err
+		}
+		logger.Debug("compact snapshots: ", n)
+	}
+
+	return nil
+}
+
+// compute provider reputations if the interval of chain time passed since the last one
+func (d *Dumper) updateReputations(head *types.Header) error {
+	if d.reputationInterval == 0 {
+		return nil
+	}
+
+	blockTime := time.Unix(int64(head.Time), 0)
+
+	last, err := database.GetLastReputationTime()
+	if err != nil {
+		return err
+	}
+	if !last.IsZero() && blockTime.Before(last.Add(d.reputationInterval)) {
+		return nil
+	}
+
+	return database.UpdateReputations(blockTime, d.reputationWeights)
+}
+
+// build a balance merkle root if enough blocks passed since the last one
+func (d *Dumper) commitBalances(head *types.Header) error {
+	if d.balanceRootBlocks == 0 {
+		return nil
+	}
+
+	blockNumber := head.Number.Uint64()
+
+	last, err := database.GetBalanceRoot(0)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && blockNumber < last.BlockNumber+d.balanceRootBlocks {
+		return nil
+	}
+
+	root, err := database.CreateBalanceRoot(blockNumber, time.Unix(int64(head.Time), 0))
+	if err != nil {
+		return err
+	}
+	logger.Info("balance root: ", root.BlockNumber, " ", root.Root)
+
+	return nil
+}
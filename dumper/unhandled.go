@@ -0,0 +1,88 @@
+package dumper
+
+import (
+	"encoding/json"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// store a log of a watched contract that no handler or mapping took.
+// ev is empty if the log did not match an event of the abi active at its block
+func (d *Dumper) storeUnhandled(log types.Log, ev ContractEvent) error {
+	if len(log.Topics) == 0 {
+		return nil
+	}
+
+	e := database.UnhandledEvent{
+		Event:       ev.Name,
+		Address:     log.Address.Hex(),
+		Topic:       log.Topics[0].Hex(),
+		BlockNumber: log.BlockNumber,
+		TxHash:      log.TxHash.Hex(),
+		LogIndex:    log.Index,
+	}
+	if c, ok := d.contracts[log.Address]; ok {
+		e.Contract = c.name
+	}
+
+	// keep raw data if it can not be decoded with the abi
+	decoded := false
+	if ev.v != nil {
+		args, err := ev.UnpackMap()
+		if err == nil {
+			b, err := json.Marshal(args)
+			if err == nil {
+				e.Args = string(b)
+				decoded = true
+			}
+		}
+	}
+	if !decoded {
+		e.Data = hexutil.Encode(log.Data)
+	}
+
+	created, err := e.CreateUnhandledEvent()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return nil
+	}
+
+	d.unhandledLk.Lock()
+	defer d.unhandledLk.Unlock()
+
+	k := eventKey{e.Contract, e.Event}
+	c := d.unhandled[k]
+	c.Contract, c.Event = e.Contract, e.Event
+	c.Count++
+	c.LastBlock = e.BlockNumber
+	d.unhandled[k] = c
+
+	// warn once per event, the count is kept in the database
+	if c.Count == 1 {
+		if e.Event == "" {
+			logger.Warnw("unknown event of watched contract, not indexed", "contract", e.Contract, "topic", e.Topic, "block", e.BlockNumber)
+		} else {
+			logger.Warnw("event without handler, not indexed", "contract", e.Contract, "event", e.Event, "block", e.BlockNumber)
+		}
+	}
+
+	return nil
+}
+
+// unhandled logs stored since start by contract and event, see database.CountUnhandledEvents for all
+func (d *Dumper) UnhandledCounts() []database.UnhandledCount {
+	d.unhandledLk.Lock()
+	defer d.unhandledLk.Unlock()
+
+	counts := make([]database.UnhandledCount, 0, len(d.unhandled))
+	for _, c := range d.unhandled {
+		counts = append(counts, c)
+	}
+
+	return counts
+}
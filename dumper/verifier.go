@@ -0,0 +1,321 @@
+package dumper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// a field of an indexed entity which differs from the contract
+type Drift struct {
+	Kind  string `json:"kind"` // provider, node or order
+	Key   string `json:"key"`
+	Field string `json:"field"`
+	DB    string `json:"db"`
+	Chain string `json:"chain"`
+}
+
+// result of a verification at a block
+type DriftReport struct {
+	BlockNumber uint64  `json:"blockNumber"`
+	Providers   int     `json:"providers"` // checked entities
+	Nodes       int     `json:"nodes"`
+	Orders      int     `json:"orders"`
+	Drifts      []Drift `json:"drifts"`
+}
+
+// Verifier reads indexed entities from the contracts and reports the differences
+type Verifier struct {
+	reader *contractReader
+}
+
+// caller can be an ethclient or the client of a simulated backend,
+// the abis of the contracts must declare the views read, see LoadABIFile
+func NewVerifier(caller ethereum.ContractCaller, registryAddress, marketAddress common.Address, registryABI, marketABI string) (*Verifier, error) {
+	rABI, err := parseContractABI(registryABI)
+	if err != nil {
+		return nil, xerrors.Errorf("registry abi: %w", err)
+	}
+	mABI, err := parseContractABI(marketABI)
+	if err != nil {
+		return nil, xerrors.Errorf("market abi: %w", err)
+	}
+
+	reader, err := newContractReader(caller, registryAddress, marketAddress, rABI, mABI)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Verifier{reader: reader}, nil
+}
+
+// balance and nonce of a provider in the market at a block, see voucher.BalanceReader
+func (v *Verifier) BalanceAt(ctx context.Context, provider common.Address, blockNumber *big.Int) (*big.Int, uint64, error) {
+	view, err := v.reader.getBalance(ctx, blockNumber, provider)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return view.Balance, view.Nonce, nil
+}
+
+// compare all indexed providers, nodes and orders with the contracts at the last indexed block,
+// nil is that block and any other block is rejected as the database does not hold its state.
+// entities missing on either side are reported as a drift of the exist field
+func (v *Verifier) Verify(ctx context.Context, blockNumber *big.Int) (DriftReport, error) {
+	cursor, err := database.GetBlockNumber()
+	if err != nil {
+		return DriftReport{}, xerrors.Errorf("get indexed block: %w", err)
+	}
+	if cursor < 1 {
+		return DriftReport{}, xerrors.New("no block is indexed")
+	}
+	// the cursor is the next block to index
+	indexed := new(big.Int).SetInt64(cursor - 1)
+	if blockNumber == nil {
+		blockNumber = indexed
+	} else if blockNumber.Cmp(indexed) != 0 {
+		return DriftReport{}, xerrors.Errorf("database is at block %d, can not verify block %d", indexed, blockNumber)
+	}
+
+	report := DriftReport{
+		BlockNumber: blockNumber.Uint64(),
+		Drifts:      []Drift{},
+	}
+
+	err = v.verifyProviders(ctx, blockNumber, &report)
+	if err != nil {
+		return DriftReport{}, err
+	}
+	err = v.verifyOrders(ctx, blockNumber, &report)
+	if err != nil {
+		return DriftReport{}, err
+	}
+
+	return report, nil
+}
+
+// compare providers and their nodes
+func (v *Verifier) verifyProviders(ctx context.Context, blockNumber *big.Int, report *DriftReport) error {
+	cps, err := v.reader.getProviders(ctx, blockNumber)
+	if err != nil {
+		return xerrors.Errorf("get providers: %w", err)
+	}
+	onChain := make(map[string]bool, len(cps))
+	for _, cp := range cps {
+		onChain[cp.Hex()] = true
+	}
+
+	providers, err := database.ListProviderEndpoints()
+	if err != nil {
+		return err
+	}
+	indexed := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		address := common.HexToAddress(p.Address).Hex()
+		indexed[address] = true
+		report.Providers++
+
+		d := differ{kind: "provider", key: p.Address}
+		if !onChain[address] {
+			d.diff("exist", true, false)
+			report.Drifts = append(report.Drifts, d.drifts...)
+			continue
+		}
+
+		view, err := v.reader.getProvider(ctx, blockNumber, common.HexToAddress(p.Address))
+		if err != nil {
+			return xerrors.Errorf("get provider %s: %w", p.Address, err)
+		}
+		d.diff("name", p.Name, view.Name)
+		d.diff("ip", p.IP, view.Ip)
+		d.diff("domain", p.Domain, view.Domain)
+		d.diff("port", p.Port, view.Port)
+
+		bv, err := v.reader.getBalance(ctx, blockNumber, common.HexToAddress(p.Address))
+		if err != nil {
+			return xerrors.Errorf("get balance of %s: %w", p.Address, err)
+		}
+		profit, err := database.GetProfitByAddress(p.Address)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		d.diff("balance", profit.Balance, bv.Balance)
+		d.diff("nonce", profit.Nonce, bv.Nonce)
+		report.Drifts = append(report.Drifts, d.drifts...)
+	}
+	for _, cp := range cps {
+		if indexed[cp.Hex()] {
+			continue
+		}
+		report.Providers++
+		d := differ{kind: "provider", key: cp.Hex()}
+		d.diff("exist", false, true)
+		report.Drifts = append(report.Drifts, d.drifts...)
+	}
+
+	nodes, err := database.ListAllNodes(0, -1)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]database.NodeStore, len(nodes))
+	for _, n := range nodes {
+		stored[nodeKey(common.HexToAddress(n.Address), n.Id)] = n
+	}
+
+	// nodes of providers on chain, then indexed nodes of providers only in the database
+	for _, cp := range cps {
+		ids, err := v.reader.getNodeIds(ctx, blockNumber, cp)
+		if err != nil {
+			return xerrors.Errorf("get node ids of %s: %w", cp.Hex(), err)
+		}
+		for _, id := range ids {
+			key := nodeKey(cp, id)
+			view, err := v.reader.getNode(ctx, blockNumber, cp, id)
+			if err != nil {
+				return xerrors.Errorf("get node %s: %w", key, err)
+			}
+
+			d := differ{kind: "node", key: key}
+			n, ok := stored[key]
+			delete(stored, key)
+			switch {
+			case ok:
+				d.diffNode(n, view)
+			case view.Exist:
+				d.diff("exist", false, true)
+			default:
+				// removed on chain and in the database
+				continue
+			}
+			report.Drifts = append(report.Drifts, d.drifts...)
+			report.Nodes++
+		}
+	}
+	for _, n := range nodes {
+		key := nodeKey(common.HexToAddress(n.Address), n.Id)
+		if _, ok := stored[key]; !ok {
+			continue
+		}
+		d := differ{kind: "node", key: key}
+		d.diff("exist", true, false)
+		report.Drifts = append(report.Drifts, d.drifts...)
+		report.Nodes++
+	}
+
+	return nil
+}
+
+// compare orders
+func (v *Verifier) verifyOrders(ctx context.Context, blockNumber *big.Int, report *DriftReport) error {
+	ids, err := v.reader.getOrderIds(ctx, blockNumber)
+	if err != nil {
+		return xerrors.Errorf("get order ids: %w", err)
+	}
+
+	orders, err := database.ListAllOrders(0, -1)
+	if err != nil {
+		return err
+	}
+	stored := make(map[uint64]database.Order, len(orders))
+	for _, o := range orders {
+		stored[o.Id] = o
+	}
+
+	for _, id := range ids {
+		view, err := v.reader.getOrder(ctx, blockNumber, id)
+		if err != nil {
+			return xerrors.Errorf("get order %d: %w", id, err)
+		}
+
+		d := differ{kind: "order", key: fmt.Sprint(id)}
+		o, ok := stored[id]
+		delete(stored, id)
+		if ok {
+			d.diffOrder(o, view)
+		} else {
+			d.diff("exist", false, true)
+		}
+		report.Drifts = append(report.Drifts, d.drifts...)
+		report.Orders++
+	}
+	for _, o := range orders {
+		if _, ok := stored[o.Id]; !ok {
+			continue
+		}
+		d := differ{kind: "order", key: fmt.Sprint(o.Id)}
+		d.diff("exist", true, false)
+		report.Drifts = append(report.Drifts, d.drifts...)
+		report.Orders++
+	}
+
+	return nil
+}
+
+func nodeKey(cp common.Address, id uint64) string {
+	return fmt.Sprintf("%s/%d", cp.Hex(), id)
+}
+
+func (d *differ) diffNode(n database.NodeStore, view nodeView) {
+	d.diff("cpuPriceMon", n.CPUPriceMon, view.CpuPriceMon)
+	d.diff("cpuPriceSec", n.CPUPriceSec, view.CpuPriceSec)
+	d.diff("cpuModel", n.CPUModel, view.CpuModel)
+	d.diff("cpuCore", n.CPUCore, view.CpuCore)
+	d.diff("gpuPriceMon", n.GPUPriceMon, view.GpuPriceMon)
+	d.diff("gpuPriceSec", n.GPUPriceSec, view.GpuPriceSec)
+	d.diff("gpuModel", n.GPUModel, view.GpuModel)
+	d.diff("memPriceMon", n.MemPriceMon, view.MemPriceMon)
+	d.diff("memPriceSec", n.MemPriceSec, view.MemPriceSec)
+	d.diff("memCapacity", n.MemCapacity, int64(view.MemNum))
+	d.diff("diskPriceMon", n.DiskPriceMon, view.DiskPriceMon)
+	d.diff("diskPriceSec", n.DiskPriceSec, view.DiskPriceSec)
+	d.diff("diskCapacity", n.DiskCapacity, int64(view.DiskNum))
+	d.diff("exist", n.Exist, view.Exist)
+	d.diff("sold", n.Sold, view.Sold)
+	d.diff("avail", n.Avail, view.Avail)
+}
+
+func (d *differ) diffOrder(o database.Order, view orderView) {
+	// times are derived from the view as when the order is indexed
+	want := CreateOrderEvent{Cp: view.Cp, Nid: view.Nid, Act: view.Act, Pro: view.Pro, Dur: view.Dur, Status: view.Status}.order(view.User)
+
+	d.diff("user", common.HexToAddress(o.User), view.User)
+	d.diff("provider", common.HexToAddress(o.Provider), view.Cp)
+	d.diff("nid", o.Nid, view.Nid)
+	d.diff("activateTime", o.ActivateTime.Unix(), want.ActivateTime.Unix())
+	d.diff("startTime", o.StartTime.Unix(), want.StartTime.Unix())
+	d.diff("endTime", o.EndTime.Unix(), want.EndTime.Unix())
+	d.diff("probation", o.Probation, want.Probation)
+	d.diff("duration", o.Duration, want.Duration)
+	d.diff("status", o.Status, view.Status)
+}
+
+// collect differing fields of an entity
+type differ struct {
+	kind   string
+	key    string
+	drifts []Drift
+}
+
+func (d *differ) diff(field string, db, chain interface{}) {
+	dbs, chains := fmt.Sprint(db), fmt.Sprint(chain)
+	if dbs == chains {
+		return
+	}
+
+	d.drifts = append(d.drifts, Drift{
+		Kind:  d.kind,
+		Key:   d.key,
+		Field: field,
+		DB:    dbs,
+		Chain: chains,
+	})
+}
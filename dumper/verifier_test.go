@@ -0,0 +1,244 @@
+package dumper
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeChain answers the view calls of registry and market from memory.
+// there is no contract bytecode in the repo to deploy on a simulated backend,
+// and the vendored bindings declare no views, so testdata abis add them
+type fakeChain struct {
+	abis      map[common.Address]abi.ABI
+	providers map[common.Address]providerView
+	nodes     map[common.Address]map[uint64]nodeView
+	orders    map[uint64]orderView
+	balances  map[common.Address]balanceView
+	time      uint64
+}
+
+func (f *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: number, Time: f.time}, nil
+}
+
+func (f *fakeChain) CallContract(ctx context.Context, msg ethereum.CallMsg, block *big.Int) ([]byte, error) {
+	a := f.abis[*msg.To]
+	m, err := a.MethodById(msg.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	args, err := m.Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch m.Name {
+	case "getProviders":
+		cps := []common.Address{}
+		for cp := range f.providers {
+			cps = append(cps, cp)
+		}
+		sort.Slice(cps, func(i, j int) bool { return cps[i].Hex() < cps[j].Hex() })
+		return m.Outputs.Pack(cps)
+	case "getProvider":
+		v := f.providers[args[0].(common.Address)]
+		return m.Outputs.Pack(v.Name, v.Ip, v.Domain, v.Port)
+	case "getNodeIds":
+		ids := []uint64{}
+		for id := range f.nodes[args[0].(common.Address)] {
+			ids = append(ids, id)
+		}
+		return m.Outputs.Pack(ids)
+	case "getNode":
+		v := f.nodes[args[0].(common.Address)][args[1].(uint64)]
+		return m.Outputs.Pack(v.CpuPriceMon, v.CpuPriceSec, v.CpuModel, v.CpuCore, v.GpuPriceMon, v.GpuPriceSec, v.GpuModel,
+			v.MemPriceMon, v.MemPriceSec, v.MemNum, v.DiskPriceMon, v.DiskPriceSec, v.DiskNum, v.Exist, v.Sold, v.Avail)
+	case "getOrderIds":
+		ids := []uint64{}
+		for id := range f.orders {
+			ids = append(ids, id)
+		}
+		return m.Outputs.Pack(ids)
+	case "getOrder":
+		v := f.orders[args[0].(uint64)]
+		return m.Outputs.Pack(v.User, v.Cp, v.Nid, v.Act, v.Pro, v.Dur, v.Status)
+	case "getBalance":
+		v, ok := f.balances[args[0].(common.Address)]
+		if !ok {
+			v.Balance = new(big.Int)
+		}
+		return m.Outputs.Pack(v.Balance, v.Nonce)
+	}
+
+	return nil, ethereum.NotFound
+}
+
+func testNode(model string) nodeView {
+	one := big.NewInt(1)
+	return nodeView{
+		CpuPriceMon: one, CpuPriceSec: one, CpuModel: model, CpuCore: 8,
+		GpuPriceMon: one, GpuPriceSec: one, GpuModel: "gpu",
+		MemPriceMon: one, MemPriceSec: one, MemNum: 2,
+		DiskPriceMon: one, DiskPriceSec: one, DiskNum: 3,
+		Exist: true, Sold: true, Avail: true,
+	}
+}
+
+var (
+	testRegistry = common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	testMarket   = common.HexToAddress("0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	testCp       = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testUser     = common.HexToAddress("0x2222222222222222222222222222222222222222")
+)
+
+// a dumper on a new database and a chain with a provider, its node and an order
+func newTestChain(t *testing.T) (*Dumper, *fakeChain) {
+	err := database.InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewGRIDDumper("", testRegistry, testMarket,
+		WithABIFile(RegistryContract, 0, "testdata/registry.json"),
+		WithABIFile(MarketContract, 0, "testdata/market.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := &fakeChain{
+		abis: map[common.Address]abi.ABI{
+			testRegistry: d.contracts[testRegistry].version(0).abi,
+			testMarket:   d.contracts[testMarket].version(0).abi,
+		},
+		providers: map[common.Address]providerView{testCp: {Name: "cp", Ip: "127.0.0.1", Port: "8080"}},
+		nodes:     map[common.Address]map[uint64]nodeView{testCp: {1: testNode("cpu")}},
+		orders:    map[uint64]orderView{1: {User: testUser, Cp: testCp, Nid: 1, Act: big.NewInt(100), Pro: big.NewInt(10), Dur: big.NewInt(1000), Status: 1}},
+		balances:  map[common.Address]balanceView{testCp: {Balance: big.NewInt(500), Nonce: 2}},
+		time:      600,
+	}
+
+	return d, chain
+}
+
+func testVerifier(t *testing.T, chain *fakeChain) *Verifier {
+	registryABI, err := LoadABIFile("testdata/registry.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	marketABI, err := LoadABIFile("testdata/market.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier(chain, testRegistry, testMarket, registryABI, marketABI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestVerifierNeedsViews(t *testing.T) {
+	// the bindings declare no views to read state with
+	_, err := NewVerifier(&fakeChain{}, testRegistry, testMarket, RegisterABI, MarketABI)
+	if err == nil {
+		t.Fatal("verifier created with abis without views")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	d, chain := newTestChain(t)
+	cp := testCp
+
+	// the database holds the state of block 10
+	_, err := d.Bootstrap(context.Background(), chain, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := testVerifier(t, chain)
+
+	report, err := v.Verify(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BlockNumber != 10 || report.Providers != 1 || report.Nodes != 1 || report.Orders != 1 || len(report.Drifts) != 0 {
+		t.Fatalf("unexpected report of an equal state: %+v", report)
+	}
+
+	_, err = v.Verify(context.Background(), big.NewInt(5))
+	if err == nil {
+		t.Fatal("verified a block other than the indexed one")
+	}
+
+	// fields changed on chain, and entities only on chain or only in the database
+	other := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	chain.nodes[cp][1] = testNode("cpu2")
+	chain.nodes[cp][2] = testNode("cpu")
+	chain.providers[other] = providerView{Name: "other"}
+	o := chain.orders[1]
+	o.Dur = big.NewInt(2000)
+	chain.orders[1] = o
+	chain.orders[2] = o
+	delete(chain.providers, cp)
+
+	report, err = v.Verify(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]Drift)
+	for _, drift := range report.Drifts {
+		got[drift.Kind+" "+drift.Key+" "+drift.Field] = drift
+	}
+	want := []Drift{
+		{Kind: "provider", Key: cp.Hex(), Field: "exist", DB: "true", Chain: "false"},
+		{Kind: "provider", Key: other.Hex(), Field: "exist", DB: "false", Chain: "true"},
+		// nodes of a provider missing on chain are not enumerated there
+		{Kind: "node", Key: nodeKey(cp, 1), Field: "exist", DB: "true", Chain: "false"},
+		{Kind: "order", Key: "1", Field: "duration", DB: "1000", Chain: "2000"},
+		{Kind: "order", Key: "1", Field: "endTime", DB: "1110", Chain: "2110"},
+		{Kind: "order", Key: "2", Field: "exist", DB: "false", Chain: "true"},
+	}
+	for _, w := range want {
+		drift, ok := got[w.Kind+" "+w.Key+" "+w.Field]
+		if !ok || drift != w {
+			t.Errorf("missing drift %+v, got %+v", w, drift)
+		}
+	}
+	if len(report.Drifts) != len(want) {
+		t.Fatalf("got %d drifts, want %d: %+v", len(report.Drifts), len(want), report.Drifts)
+	}
+
+	// with the provider back, the changed node fields and the chain only node are reported
+	chain.providers[cp] = providerView{Name: "cp", Ip: "127.0.0.1", Port: "8080"}
+	chain.balances[cp] = balanceView{Balance: big.NewInt(600), Nonce: 3}
+	report, err = v.Verify(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = make(map[string]Drift)
+	for _, drift := range report.Drifts {
+		got[drift.Kind+" "+drift.Key+" "+drift.Field] = drift
+	}
+	for _, w := range []Drift{
+		{Kind: "node", Key: nodeKey(cp, 1), Field: "cpuModel", DB: "cpu", Chain: "cpu2"},
+		{Kind: "node", Key: nodeKey(cp, 2), Field: "exist", DB: "false", Chain: "true"},
+		{Kind: "provider", Key: cp.Hex(), Field: "balance", DB: "500", Chain: "600"},
+		{Kind: "provider", Key: cp.Hex(), Field: "nonce", DB: "2", Chain: "3"},
+	} {
+		drift, ok := got[w.Kind+" "+w.Key+" "+w.Field]
+		if !ok || drift != w {
+			t.Errorf("missing drift %+v, got %+v", w, drift)
+		}
+	}
+}
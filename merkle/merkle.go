@@ -0,0 +1,96 @@
+package merkle
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var leafArgs abi.Arguments
+
+func init() {
+	addressT, _ := abi.NewType("address", "", nil)
+	uint256T, _ := abi.NewType("uint256", "", nil)
+	uint64T, _ := abi.NewType("uint64", "", nil)
+	leafArgs = abi.Arguments{{Type: addressT}, {Type: uint256T}, {Type: uint64T}}
+}
+
+// leaf of a balance: keccak256(keccak256(abi.encode(address, uint256 balance, uint64 nonce))),
+// hashed twice as openzeppelin StandardMerkleTree to avoid second preimage attacks
+func Leaf(address common.Address, balance *big.Int, nonce uint64) (common.Hash, error) {
+	data, err := leafArgs.Pack(address, balance, nonce)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	return crypto.Keccak256Hash(crypto.Keccak256(data)), nil
+}
+
+// hash of two nodes in sorted order, as openzeppelin MerkleProof
+func hashPair(a, b common.Hash) common.Hash {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+
+	return crypto.Keccak256Hash(a[:], b[:])
+}
+
+// Tree keeps all layers of a merkle tree, an odd node is promoted to the next layer
+type Tree struct {
+	layers [][]common.Hash
+}
+
+func New(leaves []common.Hash) *Tree {
+	t := &Tree{layers: [][]common.Hash{leaves}}
+	for layer := leaves; len(layer) > 1; {
+		var next []common.Hash
+		for i := 0; i < len(layer); i += 2 {
+			if i+1 == len(layer) {
+				next = append(next, layer[i])
+				continue
+			}
+			next = append(next, hashPair(layer[i], layer[i+1]))
+		}
+		t.layers = append(t.layers, next)
+		layer = next
+	}
+
+	return t
+}
+
+// root of the tree, zero if it has no leaf
+func (t *Tree) Root() common.Hash {
+	top := t.layers[len(t.layers)-1]
+	if len(top) == 0 {
+		return common.Hash{}
+	}
+
+	return top[0]
+}
+
+// sibling hashes from the i-th leaf up to the root
+func (t *Tree) Proof(i int) []common.Hash {
+	proof := []common.Hash{}
+	for _, layer := range t.layers[:len(t.layers)-1] {
+		sibling := i ^ 1
+		if sibling < len(layer) {
+			proof = append(proof, layer[sibling])
+		}
+		i /= 2
+	}
+
+	return proof
+}
+
+// verify a leaf against a root with its proof
+func Verify(root, leaf common.Hash, proof []common.Hash) bool {
+	h := leaf
+	for _, p := range proof {
+		h = hashPair(h, p)
+	}
+
+	return h == root
+}
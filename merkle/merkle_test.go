@@ -0,0 +1,150 @@
+package merkle
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// the example of the openzeppelin merkle-tree readme:
+// StandardMerkleTree.of(values, ["address", "uint256"])
+func TestStandardMerkleTreeVector(t *testing.T) {
+	addressT, _ := abi.NewType("address", "", nil)
+	uint256T, _ := abi.NewType("uint256", "", nil)
+	args := abi.Arguments{{Type: addressT}, {Type: uint256T}}
+
+	leaf := func(address string, value string) common.Hash {
+		v, _ := new(big.Int).SetString(value, 10)
+		data, err := args.Pack(common.HexToAddress(address), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crypto.Keccak256Hash(crypto.Keccak256(data))
+	}
+
+	leaves := []common.Hash{
+		leaf("0x1111111111111111111111111111111111111111", "5000000000000000000"),
+		leaf("0x2222222222222222222222222222222222222222", "2500000000000000000"),
+	}
+	want := []string{
+		"0xeb02c421cfa48976e66dfb29120745909ea3a0f843456c263cf8f1253483e283",
+		"0xb92c48e9d7abe27fd8dfd6b5dfdbfb1c9a463f80c712b66f3a5180a090cccafc",
+	}
+	for i, l := range leaves {
+		if l.Hex() != want[i] {
+			t.Fatalf("leaf %d is %s, want %s", i, l.Hex(), want[i])
+		}
+	}
+
+	tree := New(leaves)
+	root := common.HexToHash("0xd4dee0beab2d53f2cc83e567171bd2820e49898130a22622b10ead383e90bd77")
+	if tree.Root() != root {
+		t.Fatalf("root %s, want %s", tree.Root().Hex(), root.Hex())
+	}
+	for i, l := range leaves {
+		if !Verify(root, l, tree.Proof(i)) {
+			t.Fatalf("proof of leaf %d does not verify", i)
+		}
+	}
+}
+
+// the leaf is the double hash of the abi encoded words
+func TestLeaf(t *testing.T) {
+	address := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	balance := big.NewInt(1000)
+
+	var data []byte
+	data = append(data, common.LeftPadBytes(address.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(balance.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(7).Bytes(), 32)...)
+
+	leaf, err := Leaf(address, balance, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf != crypto.Keccak256Hash(crypto.Keccak256(data)) {
+		t.Fatal("leaf is not keccak256(keccak256(abi.encode(address, balance, nonce)))")
+	}
+
+	other, err := Leaf(address, balance, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == leaf {
+		t.Fatal("nonce is not in the leaf")
+	}
+}
+
+func testLeaves(n int) []common.Hash {
+	leaves := make([]common.Hash, n)
+	for i := range leaves {
+		leaves[i] = crypto.Keccak256Hash(big.NewInt(int64(i)).Bytes())
+	}
+	return leaves
+}
+
+func TestRoot(t *testing.T) {
+	if New(nil).Root() != (common.Hash{}) {
+		t.Fatal("root of an empty tree")
+	}
+
+	l := testLeaves(5)
+	h := hashPair
+
+	// an odd node is promoted, as merkletreejs with sorted pairs
+	cases := []struct {
+		leaves []common.Hash
+		root   common.Hash
+	}{
+		{l[:1], l[0]},
+		{l[:2], h(l[0], l[1])},
+		{l[:3], h(h(l[0], l[1]), l[2])},
+		{l[:4], h(h(l[0], l[1]), h(l[2], l[3]))},
+		{l[:5], h(h(h(l[0], l[1]), h(l[2], l[3])), l[4])},
+	}
+	for _, c := range cases {
+		if root := New(c.leaves).Root(); root != c.root {
+			t.Fatalf("root of %d leaves %s, want %s", len(c.leaves), root.Hex(), c.root.Hex())
+		}
+	}
+
+	// pairs are sorted as MerkleProof hashes them
+	if h(l[0], l[1]) != h(l[1], l[0]) {
+		t.Fatal("pair hash depends on the order")
+	}
+}
+
+func TestProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := testLeaves(n)
+		tree := New(leaves)
+		root := tree.Root()
+
+		for i, leaf := range leaves {
+			proof := tree.Proof(i)
+			if !Verify(root, leaf, proof) {
+				t.Fatalf("proof of leaf %d of %d does not verify", i, n)
+			}
+
+			// a single leaf is the root with an empty proof
+			if n == 1 && (len(proof) != 0 || root != leaf) {
+				t.Fatalf("single leaf proof %v root %s", proof, root.Hex())
+			}
+
+			other := crypto.Keccak256Hash(leaf[:])
+			if Verify(root, other, proof) {
+				t.Fatalf("wrong leaf verifies at %d of %d", i, n)
+			}
+			if len(proof) > 0 {
+				tampered := append([]common.Hash{}, proof...)
+				tampered[0][0] ^= 1
+				if Verify(root, leaf, tampered) {
+					t.Fatalf("tampered proof verifies at %d of %d", i, n)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+	"github.com/gridprotocol/dumper/logs"
+
+	"golang.org/x/xerrors"
+)
+
+var logger = logs.Logger("prober")
+
+type Config struct {
+	Interval time.Duration // time between two rounds
+	Timeout  time.Duration // timeout of a single probe
+
+	HealthPath string // http path checked after connect, empty to skip
+	CheckTLS   bool   // handshake tls and verify the certificate, http check uses https
+
+	FallThreshold int // consecutive failures before a provider goes offline
+	RiseThreshold int // consecutive successes before a provider goes online
+
+	Concurrency int // max providers probed at the same time
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:      time.Minute,
+		Timeout:       5 * time.Second,
+		FallThreshold: 3,
+		RiseThreshold: 2,
+		Concurrency:   16,
+	}
+}
+
+// status of a provider with hysteresis
+type state struct {
+	known  bool // status is decided after start
+	online bool
+	rise   int
+	fall   int
+
+	// time of the first probe of the current successes or failures
+	since time.Time
+}
+
+// Prober checks the registered endpoint of each provider and sets its nodes online or offline
+type Prober struct {
+	cfg Config
+
+	// roots verifying provider certificates, system roots if nil
+	roots  *x509.CertPool
+	client *http.Client
+
+	lk     sync.Mutex
+	states map[string]*state
+}
+
+func New(cfg Config) *Prober {
+	def := DefaultConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.FallThreshold <= 0 {
+		cfg.FallThreshold = def.FallThreshold
+	}
+	if cfg.RiseThreshold <= 0 {
+		cfg.RiseThreshold = def.RiseThreshold
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = def.Concurrency
+	}
+
+	return &Prober{
+		cfg:    cfg,
+		client: http.DefaultClient,
+		states: make(map[string]*state),
+	}
+}
+
+// probe all providers every interval until ctx is done
+func (p *Prober) Run(ctx context.Context) {
+	for {
+		err := p.ProbeAll(ctx)
+		if err != nil {
+			logger.Debug("probe providers error: ", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.cfg.Interval):
+		}
+	}
+}
+
+// probe all providers once
+func (p *Prober) ProbeAll(ctx context.Context) error {
+	providers, err := database.ListProviderEndpoints()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, p.cfg.Concurrency)
+	for _, provider := range providers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(provider database.Provider) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := p.ProbeProvider(ctx, provider)
+			if err != nil {
+				logger.Debug("probe provider error: ", provider.Address, " ", err.Error())
+			}
+		}(provider)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// probe a provider, record the result and update its nodes when the status changes
+func (p *Prober) ProbeProvider(ctx context.Context, provider database.Provider) error {
+	start := time.Now()
+	perr := p.Check(ctx, provider)
+	latency := time.Since(start)
+
+	online, changed, since := p.update(provider.Address, perr == nil, start)
+
+	record := database.ProbeRecord{
+		Address: provider.Address,
+		Time:    start,
+		Success: perr == nil,
+		Latency: latency.Milliseconds(),
+		Online:  online,
+	}
+	if perr != nil {
+		record.Error = perr.Error()
+	}
+	err := record.CreateProbeRecord()
+	if err != nil {
+		return err
+	}
+
+	if !changed {
+		return nil
+	}
+
+	logger.Info("provider online changed: ", provider.Address, " ", online)
+
+	// the outage starts or ends at the first probe that crossed the threshold
+	return database.SetProviderStatus(provider.Address, online, since)
+}
+
+// apply a probe result at t, return the status, whether it changed
+// and the time of the first probe that led to the change
+func (p *Prober) update(address string, success bool, t time.Time) (bool, bool, time.Time) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	st, ok := p.states[address]
+	if !ok {
+		st = &state{}
+		p.states[address] = st
+	}
+
+	// the status in db may be stale after restart, so the first decision is always applied
+	if success {
+		if st.rise == 0 {
+			st.since = t
+		}
+		st.rise++
+		st.fall = 0
+		if st.rise >= p.cfg.RiseThreshold && (!st.known || !st.online) {
+			st.known, st.online = true, true
+			return true, true, st.since
+		}
+	} else {
+		if st.fall == 0 {
+			st.since = t
+		}
+		st.fall++
+		st.rise = 0
+		if st.fall >= p.cfg.FallThreshold && (!st.known || st.online) {
+			st.known, st.online = true, false
+			return false, true, st.since
+		}
+	}
+
+	return st.online, false, st.since
+}
+
+// check the endpoint of a provider: tcp connect, then tls and http health if configured
+func (p *Prober) Check(ctx context.Context, provider database.Provider) error {
+	host := provider.Domain
+	if host == "" {
+		host = provider.IP
+	}
+	if host == "" || provider.Port == "" {
+		return xerrors.Errorf("provider %s has no endpoint", provider.Address)
+	}
+	addr := net.JoinHostPort(host, provider.Port)
+
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return xerrors.Errorf("connect %s: %w", addr, err)
+	}
+
+	if p.cfg.CheckTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, RootCAs: p.roots})
+		err = tlsConn.HandshakeContext(ctx)
+		tlsConn.Close()
+		if err != nil {
+			return xerrors.Errorf("tls %s: %w", addr, err)
+		}
+	} else {
+		conn.Close()
+	}
+
+	if p.cfg.HealthPath == "" {
+		return nil
+	}
+
+	scheme := "http"
+	if p.cfg.CheckTLS {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s%s", scheme, addr, p.cfg.HealthPath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("health %s: %w", url, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.Errorf("health %s: status %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
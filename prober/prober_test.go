@@ -0,0 +1,279 @@
+package prober
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+)
+
+// a provider registered with the endpoint of a listener address
+func endpoint(t *testing.T, addr string) database.Provider {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return database.Provider{Address: "0x1111111111111111111111111111111111111111", IP: host, Port: port}
+}
+
+func TestCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	p := New(Config{Timeout: time.Second})
+	provider := endpoint(t, l.Addr().String())
+
+	err = p.Check(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing listens after close
+	l.Close()
+	err = p.Check(context.Background(), provider)
+	if err == nil {
+		t.Fatal("connect to a closed port succeeded")
+	}
+
+	err = p.Check(context.Background(), database.Provider{Address: provider.Address})
+	if err == nil {
+		t.Fatal("check without endpoint succeeded")
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	p := New(Config{Timeout: time.Second, CheckTLS: true})
+	provider := endpoint(t, srv.Listener.Addr().String())
+
+	// the self signed certificate is not trusted by the system roots
+	err := p.Check(context.Background(), provider)
+	if err == nil {
+		t.Fatal("untrusted certificate accepted")
+	}
+
+	p.roots = x509.NewCertPool()
+	p.roots.AddCert(srv.Certificate())
+	err = p.Check(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a plain tcp server fails the handshake
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	err = p.Check(context.Background(), endpoint(t, plain.Listener.Addr().String()))
+	if err == nil {
+		t.Fatal("tls handshake with a plain server succeeded")
+	}
+}
+
+func TestCheckHealthPath(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	p := New(Config{Timeout: time.Second, HealthPath: "/health"})
+	provider := endpoint(t, srv.Listener.Addr().String())
+
+	err := p.Check(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthy = false
+	err = p.Check(context.Background(), provider)
+	if err == nil {
+		t.Fatal("unhealthy status accepted")
+	}
+
+	p.cfg.HealthPath = "/missing"
+	healthy = true
+	err = p.Check(context.Background(), provider)
+	if err == nil {
+		t.Fatal("missing health path accepted")
+	}
+}
+
+func TestCheckHealthPathTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	p := New(Config{Timeout: time.Second, CheckTLS: true, HealthPath: "/health"})
+	p.roots = x509.NewCertPool()
+	p.roots.AddCert(srv.Certificate())
+	p.client = srv.Client()
+
+	err := p.Check(context.Background(), endpoint(t, srv.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateHysteresis(t *testing.T) {
+	p := New(Config{FallThreshold: 3, RiseThreshold: 2})
+	address := "0x1111111111111111111111111111111111111111"
+	base := time.Unix(1700000000, 0)
+	at := func(i int) time.Time { return base.Add(time.Duration(i) * time.Minute) }
+
+	steps := []struct {
+		success bool
+		online  bool
+		changed bool
+		since   time.Time
+	}{
+		// the first decision is applied after the threshold
+		{true, false, false, at(0)},
+		{true, true, true, at(0)},
+		{true, true, false, at(0)},
+		// failures below the fall threshold keep it online
+		{false, true, false, at(3)},
+		{false, true, false, at(3)},
+		{true, true, false, at(5)},
+		// the outage starts at the first failed probe
+		{false, true, false, at(6)},
+		{false, true, false, at(6)},
+		{false, false, true, at(6)},
+		{false, false, false, at(6)},
+		// and ends at the first successful probe
+		{true, false, false, at(10)},
+		{false, false, false, at(11)},
+		{true, false, false, at(12)},
+		{true, true, true, at(12)},
+	}
+
+	for i, s := range steps {
+		online, changed, since := p.update(address, s.success, at(i))
+		if online != s.online || changed != s.changed || !since.Equal(s.since) {
+			t.Fatalf("step %d: got online %v changed %v since %v, want %v %v %v", i, online, changed, since, s.online, s.changed, s.since)
+		}
+	}
+}
+
+func TestProbeProvider(t *testing.T) {
+	err := database.InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// the same provider at a closed port
+	up := endpoint(t, l.Addr().String())
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := endpoint(t, closed.Addr().String())
+	closed.Close()
+
+	node := database.NodeStore{Address: up.Address, Id: 1, Exist: true}
+	err = node.CreateNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(Config{Timeout: time.Second, FallThreshold: 2, RiseThreshold: 1})
+	probe := func(provider database.Provider, online bool) database.ProbeRecord {
+		t.Helper()
+		err := p.ProbeProvider(context.Background(), provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := database.GetNodeByCpAndId(node.Address, node.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Online != online {
+			t.Fatalf("node online %v, want %v", n.Online, online)
+		}
+		status, err := database.ProviderOnline(node.Address)
+		if err != nil || status != online {
+			t.Fatalf("provider online %v, want %v: %v", status, online, err)
+		}
+
+		records, err := database.ListProbeRecords(node.Address, time.Time{}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records[len(records)-1]
+	}
+	outages := func() []database.Outage {
+		t.Helper()
+		outages, err := database.ListOutages(node.Address, time.Time{}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return outages
+	}
+
+	r := probe(up, true)
+	if !r.Success || !r.Online {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if len(outages()) != 0 {
+		t.Fatal("outage of an online provider")
+	}
+
+	// one failure stays online, the second opens an outage from the first
+	first := probe(down, true)
+	if first.Success || first.Error == "" {
+		t.Fatalf("unexpected record %+v", first)
+	}
+	if len(outages()) != 0 {
+		t.Fatal("outage below the fall threshold")
+	}
+	probe(down, false)
+	o := outages()
+	if len(o) != 1 || !o[0].Start.Equal(first.Time) || o[0].End != nil {
+		t.Fatalf("unexpected outages %+v, want one open from %v", o, first.Time)
+	}
+
+	// the outage ends at the first successful probe
+	r = probe(up, true)
+	o = outages()
+	if len(o) != 1 || o[0].End == nil || !o[0].End.Equal(r.Time) {
+		t.Fatalf("unexpected outages %+v, want one closed at %v", o, r.Time)
+	}
+}
@@ -0,0 +1,205 @@
+package voucher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gridprotocol/dumper/database"
+	"github.com/gridprotocol/dumper/logs"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"golang.org/x/xerrors"
+)
+
+var logger = logs.Logger("voucher")
+
+type Config struct {
+	KeyFile  string // keystore file of the signing key
+	Password string
+
+	ChainID *big.Int
+	Market  common.Address // verifying contract
+
+	// balances on chain, a voucher is only signed if they match the indexed ones
+	Chain BalanceReader
+
+	// eip712 domain
+	Name    string
+	Version string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Name:    "GRID Market",
+		Version: "1",
+	}
+}
+
+// BalanceReader reads the balance and nonce of a provider from the market contract at a block
+type BalanceReader interface {
+	BalanceAt(ctx context.Context, provider common.Address, blockNumber *big.Int) (*big.Int, uint64, error)
+}
+
+// typed data of a withdrawal voucher
+var voucherTypes = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "verifyingContract", Type: "address"},
+	},
+	"Withdraw": {
+		{Name: "provider", Type: "address"},
+		{Name: "amount", Type: "uint256"},
+		{Name: "nonce", Type: "uint64"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "market", Type: "address"},
+	},
+}
+
+// Signer issues eip712 signed withdrawal vouchers from indexed balances
+type Signer struct {
+	cfg Config
+	key *ecdsa.PrivateKey
+
+	// one voucher is issued at a time
+	lk sync.Mutex
+}
+
+func New(cfg Config) (*Signer, error) {
+	if cfg.ChainID == nil || cfg.ChainID.Sign() <= 0 {
+		return nil, xerrors.New("chain id is required")
+	}
+	if cfg.Market == (common.Address{}) {
+		return nil, xerrors.New("market address is required")
+	}
+	if cfg.Chain == nil {
+		return nil, xerrors.New("chain balance reader is required")
+	}
+	def := DefaultConfig()
+	if cfg.Name == "" {
+		cfg.Name = def.Name
+	}
+	if cfg.Version == "" {
+		cfg.Version = def.Version
+	}
+
+	keyJSON, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptKey(keyJSON, cfg.Password)
+	if err != nil {
+		return nil, xerrors.Errorf("decrypt key %s: %w", cfg.KeyFile, err)
+	}
+
+	return &Signer{
+		cfg: cfg,
+		key: key.PrivateKey,
+	}, nil
+}
+
+// address of the signing key
+func (s *Signer) Address() common.Address {
+	return crypto.PubkeyToAddress(s.key.PublicKey)
+}
+
+// typed data of a voucher under the configured domain
+func (s *Signer) TypedData(provider common.Address, amount *big.Int, nonce uint64) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types:       voucherTypes,
+		PrimaryType: "Withdraw",
+		Domain: apitypes.TypedDataDomain{
+			Name:              s.cfg.Name,
+			Version:           s.cfg.Version,
+			ChainId:           (*math.HexOrDecimal256)(s.cfg.ChainID),
+			VerifyingContract: s.cfg.Market.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"provider": provider.Hex(),
+			"amount":   amount.String(),
+			"nonce":    new(big.Int).SetUint64(nonce).String(),
+			"chainId":  s.cfg.ChainID.String(),
+			"market":   s.cfg.Market.Hex(),
+		},
+	}
+}
+
+// sign a voucher of the whole withdrawable amount of a provider at its current nonce.
+// the amount and nonce come from indexed state, and are refused unless the market
+// reports the same at the indexed block. every voucher is recorded
+func (s *Signer) Sign(ctx context.Context, provider common.Address) (database.Voucher, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	cursor, err := database.GetBlockNumber()
+	if err != nil {
+		return database.Voucher{}, xerrors.Errorf("get indexed block: %w", err)
+	}
+	if cursor < 1 {
+		return database.Voucher{}, xerrors.New("no block is indexed")
+	}
+	// the cursor is the next block to index
+	block := big.NewInt(cursor - 1)
+
+	amount, nonce, err := database.GetWithdrawable(provider.Hex())
+	if err != nil {
+		return database.Voucher{}, err
+	}
+	if amount.Sign() <= 0 {
+		return database.Voucher{}, xerrors.Errorf("nothing to withdraw for %s", provider.Hex())
+	}
+
+	chainAmount, chainNonce, err := s.cfg.Chain.BalanceAt(ctx, provider, block)
+	if err != nil {
+		return database.Voucher{}, xerrors.Errorf("get balance of %s at %d: %w", provider.Hex(), block, err)
+	}
+	if chainAmount == nil || chainAmount.Cmp(amount) != 0 || chainNonce != nonce {
+		logger.Warnw("voucher refused, indexed balance differs from the market", "provider", provider.Hex(), "block", block,
+			"amount", amount.String(), "nonce", nonce, "chainAmount", fmt.Sprint(chainAmount), "chainNonce", chainNonce)
+		return database.Voucher{}, xerrors.Errorf("balance of %s is %s/%d, market reports %v/%d at block %d",
+			provider.Hex(), amount, nonce, chainAmount, chainNonce, block)
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(s.TypedData(provider, amount, nonce))
+	if err != nil {
+		return database.Voucher{}, err
+	}
+
+	sig, err := crypto.Sign(hash, s.key)
+	if err != nil {
+		return database.Voucher{}, err
+	}
+	// v in 27/28 as ecrecover expects
+	sig[crypto.RecoveryIDOffset] += 27
+
+	v := database.Voucher{
+		Provider:  provider.Hex(),
+		Amount:    amount,
+		Nonce:     nonce,
+		ChainId:   s.cfg.ChainID,
+		Market:    s.cfg.Market.Hex(),
+		Signer:    s.Address().Hex(),
+		Signature: hexutil.Encode(sig),
+		Time:      time.Now(),
+	}
+
+	err = v.CreateVoucher()
+	if err != nil {
+		return database.Voucher{}, err
+	}
+
+	logger.Info("voucher issued: ", v.Provider, " amount: ", v.Amount, " nonce: ", v.Nonce)
+
+	return v, nil
+}
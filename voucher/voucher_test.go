@@ -0,0 +1,135 @@
+package voucher
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/gridprotocol/dumper/database"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// balances reported by the market, and the block they were read at
+type fakeMarket struct {
+	balance *big.Int
+	nonce   uint64
+	block   *big.Int
+}
+
+func (m *fakeMarket) BalanceAt(ctx context.Context, provider common.Address, blockNumber *big.Int) (*big.Int, uint64, error) {
+	m.block = blockNumber
+	return m.balance, m.nonce, nil
+}
+
+func TestSignRecover(t *testing.T) {
+	err := database.InitDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	market := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	profit := database.Profit{Address: provider.Hex(), Balance: big.NewInt(1000), Profit: big.NewInt(0), Penalty: big.NewInt(0), Nonce: 3}
+	err = profit.CreateProfit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := keystore.NewKeyStore(t.TempDir(), keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.ImportECDSA(key, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := &fakeMarket{balance: big.NewInt(900), nonce: 3}
+	s, err := New(Config{KeyFile: account.URL.Path, Password: "pw", ChainID: big.NewInt(1337), Market: market, Chain: chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// refused without an indexed block
+	_, err = s.Sign(context.Background(), provider)
+	if err == nil {
+		t.Fatal("signed without an indexed block")
+	}
+	err = database.SetBlockNumber(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// refused while the market reports another balance or nonce
+	_, err = s.Sign(context.Background(), provider)
+	if err == nil {
+		t.Fatal("signed a balance the market does not report")
+	}
+	if chain.block == nil || chain.block.Uint64() != 20 {
+		t.Fatalf("balance read at block %v, want the indexed block 20", chain.block)
+	}
+	chain.balance, chain.nonce = big.NewInt(1000), 2
+	_, err = s.Sign(context.Background(), provider)
+	if err == nil {
+		t.Fatal("signed a nonce the market does not report")
+	}
+
+	chain.nonce = 3
+	v, err := s.Sign(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Amount.Cmp(big.NewInt(1000)) != 0 || v.Nonce != 3 {
+		t.Fatalf("voucher amount %s nonce %d", v.Amount, v.Nonce)
+	}
+
+	// encode the digest by hand as a contract would
+	u256 := func(x *big.Int) []byte { return common.LeftPadBytes(x.Bytes(), 32) }
+	domain := crypto.Keccak256(
+		crypto.Keccak256([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)")),
+		crypto.Keccak256([]byte("GRID Market")),
+		crypto.Keccak256([]byte("1")),
+		u256(big.NewInt(1337)),
+		common.LeftPadBytes(market.Bytes(), 32),
+	)
+	message := crypto.Keccak256(
+		crypto.Keccak256([]byte("Withdraw(address provider,uint256 amount,uint64 nonce,uint256 chainId,address market)")),
+		common.LeftPadBytes(provider.Bytes(), 32),
+		u256(big.NewInt(1000)),
+		u256(big.NewInt(3)),
+		u256(big.NewInt(1337)),
+		common.LeftPadBytes(market.Bytes(), 32),
+	)
+	digest := crypto.Keccak256([]byte{0x19, 0x01}, domain, message)
+
+	sig, err := hexutil.Decode(v.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig) != 65 || (sig[64] != 27 && sig[64] != 28) {
+		t.Fatalf("signature v %d", sig[64])
+	}
+	sig[64] -= 27
+
+	pub, err := crypto.SigToPub(digest, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := crypto.PubkeyToAddress(*pub); got != s.Address() || got.Hex() != v.Signer {
+		t.Fatalf("recovered %s, signer %s", got.Hex(), v.Signer)
+	}
+
+	// every issued voucher is recorded
+	vs, err := database.ListVouchers(provider.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 {
+		t.Fatalf("%d vouchers recorded", len(vs))
+	}
+}